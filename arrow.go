@@ -0,0 +1,67 @@
+package stick
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// An Arrow is an arrow function defined in a template, such as:
+//
+//	{{ items|map(i => i.name)|join(', ') }}
+//
+// An Arrow captures the variables in scope where it is defined. Filters and
+// functions that receive an Arrow can call it with Context.Call.
+type Arrow struct {
+	node  *parse.ArrowExpr
+	state *State           // The State the Arrow was defined in.
+	scope map[string]Value // Variables captured when the Arrow was defined.
+}
+
+// Args returns the names of the arguments the Arrow accepts.
+func (a *Arrow) Args() []string {
+	return a.node.Args
+}
+
+// String returns a string representation of the Arrow.
+func (a *Arrow) String() string {
+	return a.node.String()
+}
+
+// newArrow returns an Arrow that captures the current scope.
+func (s *State) newArrow(exp *parse.ArrowExpr) *Arrow {
+	return &Arrow{exp, s, s.scope.All()}
+}
+
+// Call calls the given Arrow with args. Missing arguments are null, and
+// extra arguments are ignored.
+func (s *State) Call(fn Value, args ...Value) (Value, error) {
+	a, ok := fn.(*Arrow)
+	if !ok {
+		return nil, fmt.Errorf("stick: unable to call %T, expected an arrow function", fn)
+	}
+	if a.state == nil {
+		return nil, errors.New("stick: unable to call an uninitialized arrow function")
+	}
+	return a.state.callArrow(a, args...)
+}
+
+// callArrow evaluates the body of the Arrow with its captured variables and
+// the given arguments in scope.
+func (s *State) callArrow(a *Arrow, args ...Value) (Value, error) {
+	locals := make(map[string]Value, len(a.node.Args))
+	for i, name := range a.node.Args {
+		if i < len(args) {
+			locals[name] = args[i]
+		} else {
+			locals[name] = nil
+		}
+	}
+	defer func(scope *scopeStack) {
+		s.scope = scope
+	}(s.scope)
+	defer s.isolateUndefined()()
+	s.scope = &scopeStack{[]map[string]Value{a.scope, locals}}
+	return s.EvalExpr(a.node.Body)
+}
@@ -0,0 +1,254 @@
+package stick
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// A TemplateCache stores parsed templates so they need not be loaded and parsed
+// each time they are used.
+//
+// Cached templates are identified by name and a key describing the version of
+// the template's source. The key is provided by the Loader, if it implements the
+// CacheKeyLoader interface, otherwise it is always empty. A TemplateCache must be
+// safe for concurrent use.
+type TemplateCache interface {
+	// Get returns the cached Tree with the given name and key, if one exists.
+	Get(name, key string) (*parse.Tree, bool)
+
+	// Set stores the given Tree, replacing any existing Tree with the same name.
+	Set(name, key string, tree *parse.Tree)
+
+	// Invalidate removes the Tree with the given name from the cache.
+	Invalidate(name string)
+}
+
+// A CacheKeyLoader is a Loader that can describe the current version of a template.
+//
+// A template is parsed again when its cache key changes.
+type CacheKeyLoader interface {
+	Loader
+
+	// CacheKey returns a key that changes whenever the contents of the named template change.
+	CacheKey(name string) (string, error)
+}
+
+type cacheEntry struct {
+	name string
+	key  string
+	tree *parse.Tree
+}
+
+// MemoryTemplateCache is an in-memory TemplateCache that holds a limited number of
+// templates, discarding the least recently used template when full.
+type MemoryTemplateCache struct {
+	size    int
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryTemplateCache returns a MemoryTemplateCache holding at most size templates.
+// If size is zero or less, the number of cached templates is not limited.
+func NewMemoryTemplateCache(size int) *MemoryTemplateCache {
+	return &MemoryTemplateCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached Tree with the given name and key, if one exists.
+func (c *MemoryTemplateCache) Get(name, key string) (*parse.Tree, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if e.key != key {
+		// Stale entry; the template source has changed.
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.tree, true
+}
+
+// Set stores the given Tree, replacing any existing Tree with the same name.
+func (c *MemoryTemplateCache) Set(name, key string, tree *parse.Tree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		el.Value = &cacheEntry{name, key, tree}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[name] = c.order.PushFront(&cacheEntry{name, key, tree})
+	if c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate removes the Tree with the given name from the cache.
+func (c *MemoryTemplateCache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of templates in the cache.
+func (c *MemoryTemplateCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *MemoryTemplateCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).name)
+}
+
+// A FragmentCache stores the rendered output of cache tags, so their body
+// need not be executed each time the template is rendered.
+//
+// Cached fragments are identified by the key given in the cache tag, and may
+// have any number of tags used to invalidate related fragments at once. A
+// FragmentCache must be safe for concurrent use.
+type FragmentCache interface {
+	// Get returns the cached output with the given key, if one exists.
+	Get(key string) (string, bool)
+
+	// Set stores the given output, replacing any existing output with the same
+	// key. If ttl is greater than zero, the output expires after ttl, otherwise
+	// it never expires.
+	Set(key, val string, ttl time.Duration, tags []string)
+
+	// Invalidate removes the output with the given key from the cache.
+	Invalidate(key string)
+
+	// InvalidateTags removes all output with any of the given tags from the cache.
+	InvalidateTags(tags ...string)
+}
+
+type fragmentEntry struct {
+	key     string
+	val     string
+	expires time.Time // Zero if the entry does not expire.
+	tags    []string
+}
+
+// MemoryFragmentCache is an in-memory FragmentCache that holds a limited number
+// of fragments, discarding the least recently used fragment when full. Expired
+// fragments are removed when they are read, or discarded like any other
+// fragment once they are the least recently used.
+type MemoryFragmentCache struct {
+	size    int
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]bool // Keys of the entries with each tag.
+	now     func() time.Time
+}
+
+// NewMemoryFragmentCache returns a MemoryFragmentCache holding at most size
+// fragments. If size is zero or less, the number of cached fragments is not
+// limited.
+func NewMemoryFragmentCache(size int) *MemoryFragmentCache {
+	return &MemoryFragmentCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]bool),
+		now:     time.Now,
+	}
+}
+
+// Get returns the cached output with the given key, if one exists.
+func (c *MemoryFragmentCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*fragmentEntry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.remove(key)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return e.val, true
+}
+
+// Set stores the given output, replacing any existing output with the same
+// key. If ttl is greater than zero, the output expires after ttl, otherwise
+// it never expires.
+func (c *MemoryFragmentCache) Set(key, val string, ttl time.Duration, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	e := &fragmentEntry{key: key, val: val, tags: tags}
+	if ttl > 0 {
+		e.expires = c.now().Add(ttl)
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]bool)
+			c.tags[tag] = keys
+		}
+		keys[key] = true
+	}
+	if c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back().Value.(*fragmentEntry).key)
+	}
+}
+
+// Invalidate removes the output with the given key from the cache.
+func (c *MemoryFragmentCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+// InvalidateTags removes all output with any of the given tags from the cache.
+func (c *MemoryFragmentCache) InvalidateTags(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			c.remove(key)
+		}
+	}
+}
+
+// Len returns the number of fragments in the cache, including any that have
+// expired but not yet been removed.
+func (c *MemoryFragmentCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *MemoryFragmentCache) remove(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	e := c.order.Remove(el).(*fragmentEntry)
+	delete(c.entries, key)
+	for _, tag := range e.tags {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
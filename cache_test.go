@@ -0,0 +1,192 @@
+package stick
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// countingLoader wraps a MemoryLoader and counts calls to Load.
+type countingLoader struct {
+	*MemoryLoader
+	loads int
+}
+
+func (l *countingLoader) Load(name string) (Template, error) {
+	l.loads++
+	return l.MemoryLoader.Load(name)
+}
+
+func TestMemoryTemplateCache(t *testing.T) {
+	c := NewMemoryTemplateCache(2)
+	a, b, d := &parse.Tree{}, &parse.Tree{}, &parse.Tree{}
+	c.Set("a", "1", a)
+	c.Set("b", "1", b)
+	if tree, ok := c.Get("a", "1"); !ok || tree != a {
+		t.Errorf("expected cached tree for a")
+	}
+	c.Set("d", "1", d)
+	if _, ok := c.Get("b", "1"); ok {
+		t.Errorf("expected least recently used tree b to be evicted")
+	}
+	if _, ok := c.Get("a", "2"); ok {
+		t.Errorf("expected stale tree for a to be discarded")
+	}
+	if _, ok := c.Get("a", "1"); ok {
+		t.Errorf("expected stale tree for a to be removed")
+	}
+	c.Invalidate("d")
+	if l := c.Len(); l != 0 {
+		t.Errorf("expected empty cache, got %d entries", l)
+	}
+}
+
+func TestEnvCache(t *testing.T) {
+	l := &countingLoader{MemoryLoader: &MemoryLoader{map[string]string{
+		"base.twig":  `{% block body %}{% endblock %}!`,
+		"child.twig": `{% extends 'base.twig' %}{% block body %}Hello, {{ name }}{% endblock %}`,
+	}}}
+	env := New(l)
+	env.Cache = NewMemoryTemplateCache(0)
+	render := func(name string) string {
+		buf := &bytes.Buffer{}
+		if err := env.Execute("child.twig", buf, map[string]Value{"name": name}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return buf.String()
+	}
+
+	if res := render("World"); res != "Hello, World!" {
+		t.Errorf("unexpected output %#v", res)
+	}
+	if res := render("Tyler"); res != "Hello, Tyler!" {
+		t.Errorf("unexpected output %#v", res)
+	}
+	if l.loads != 2 {
+		t.Errorf("expected 2 loads, got %d", l.loads)
+	}
+
+	l.Templates["base.twig"] = `{% block body %}{% endblock %}?`
+	if res := render("World"); res != "Hello, World?" {
+		t.Errorf("expected modified template to be parsed again, got %#v", res)
+	}
+	if l.loads != 3 {
+		t.Errorf("expected 3 loads, got %d", l.loads)
+	}
+}
+
+func TestMemoryFragmentCache(t *testing.T) {
+	c := NewMemoryFragmentCache(0)
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+	c.Set("a", "A", 0, []string{"nav"})
+	c.Set("b", "B", time.Minute, []string{"nav", "footer"})
+	c.Set("d", "D", 0, []string{"footer"})
+	if val, ok := c.Get("b"); !ok || val != "B" {
+		t.Errorf("expected cached fragment for b")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected fragment b to be expired")
+	}
+	if val, ok := c.Get("a"); !ok || val != "A" {
+		t.Errorf("expected fragment a without ttl to be cached")
+	}
+	c.Set("b", "B", 0, []string{"footer"})
+	c.InvalidateTags("nav")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected fragment a to be invalidated by tag")
+	}
+	if l := c.Len(); l != 2 {
+		t.Errorf("expected 2 fragments, got %d", l)
+	}
+	c.Invalidate("b")
+	c.InvalidateTags("footer")
+	if l := c.Len(); l != 0 {
+		t.Errorf("expected empty cache, got %d entries", l)
+	}
+}
+
+func TestMemoryFragmentCacheSize(t *testing.T) {
+	c := NewMemoryFragmentCache(2)
+	c.Set("a", "A", 0, []string{"nav"})
+	c.Set("b", "B", 0, nil)
+	c.Get("a")
+	c.Set("c", "C", 0, nil)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected least recently used fragment b to be discarded")
+	}
+	c.Set("d", "D", 0, nil)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected least recently used fragment a to be discarded")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Errorf("expected fragment c to be cached")
+	}
+	if l := len(c.tags); l != 0 {
+		t.Errorf("expected tags of discarded fragments to be removed, got %d", l)
+	}
+	if l := c.Len(); l != 2 {
+		t.Errorf("expected 2 fragments, got %d", l)
+	}
+}
+
+func TestCacheTag(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"page.twig": `{% cache 'nav' ~ section tags(['nav']) %}<nav>{{ name }}</nav>{% endcache %} {{ name }}`,
+	}})
+	render := func(name string) string {
+		buf := &bytes.Buffer{}
+		if err := env.Execute("page.twig", buf, map[string]Value{"name": name, "section": "main"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return buf.String()
+	}
+
+	if res := render("a"); res != "<nav>a</nav> a" {
+		t.Errorf("unexpected output without a cache %#v", res)
+	}
+	if res := render("b"); res != "<nav>b</nav> b" {
+		t.Errorf("unexpected output without a cache %#v", res)
+	}
+
+	c := NewMemoryFragmentCache(0)
+	env.FragmentCache = c
+	if res := render("a"); res != "<nav>a</nav> a" {
+		t.Errorf("unexpected output %#v", res)
+	}
+	if res := render("b"); res != "<nav>a</nav> b" {
+		t.Errorf("expected cached fragment, got %#v", res)
+	}
+	if val, ok := c.Get("navmain"); !ok || val != "<nav>a</nav>" {
+		t.Errorf("expected fragment to be cached by key, got %#v", val)
+	}
+	c.InvalidateTags("nav")
+	if res := render("b"); res != "<nav>b</nav> b" {
+		t.Errorf("expected invalidated fragment to be rendered again, got %#v", res)
+	}
+}
+
+func TestEnvCacheStrings(t *testing.T) {
+	c := NewMemoryTemplateCache(0)
+	env := New(&MemoryLoader{map[string]string{}})
+	env.Cache = c
+	if _, err := env.LoadString("Hello"); err != nil {
+		t.Fatal(err)
+	}
+	if l := c.Len(); l != 0 {
+		t.Errorf("expected templates loaded with LoadString not to be cached, got %d entries", l)
+	}
+	env = New(nil)
+	env.Cache = c
+	for i := 0; i < 2; i++ {
+		if err := env.Execute("Hello, {{ name }}", &bytes.Buffer{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l := c.Len(); l != 1 {
+		t.Errorf("expected templates executed with the default loader to be cached, got %d entries", l)
+	}
+}
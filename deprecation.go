@@ -0,0 +1,41 @@
+package stick
+
+import (
+	"fmt"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// Deprecation describes the use of a deprecated feature in a template.
+type Deprecation struct {
+	Name    string    // The name of the template.
+	Pos     parse.Pos // The position of the deprecated usage.
+	Message string    // A message describing the deprecation.
+}
+
+// A DeprecationHandler is called when a template uses a deprecated feature,
+// such as the "filter" tag, a deprecated tag, or a function, filter or test
+// marked as deprecated in the Env.
+type DeprecationHandler func(ctx Context, d Deprecation)
+
+// deprecated reports the use of a deprecated feature at pos to the Env's
+// DeprecationHandler, if any.
+func (s *State) deprecated(pos parse.Pos, msg string) {
+	if h := s.env.DeprecationHandler; h != nil {
+		h(s, Deprecation{s.name, pos, msg})
+	}
+}
+
+// deprecatedUse reports the use of the named function, filter or test at pos,
+// if it is marked as deprecated in the given map.
+func (s *State) deprecatedUse(kind, name string, deprecated map[string]string, pos parse.Pos) {
+	msg, ok := deprecated[name]
+	if !ok {
+		return
+	}
+	desc := fmt.Sprintf(`the "%s" %s is deprecated`, name, kind)
+	if msg != "" {
+		desc += ", " + msg
+	}
+	s.deprecated(pos, desc)
+}
@@ -0,0 +1,98 @@
+package stick
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestDeprecationHandler(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"filter.twig": "{% apply upper %}a{% endapply %}\n{% filter upper %}b{% endfilter %}\n{% spaceless %}c{% endspaceless %}",
+	}})
+	env.Filters["upper"] = func(ctx Context, val Value, args ...Value) Value {
+		return CoerceString(val) + "!"
+	}
+
+	buf := &bytes.Buffer{}
+	if err := env.Execute("filter.twig", buf, nil); err != nil {
+		t.Fatalf("unexpected error without handler: %s", err)
+	}
+
+	var seen []string
+	env.DeprecationHandler = func(ctx Context, d Deprecation) {
+		seen = append(seen, fmt.Sprintf("%s:%d:%d %s", d.Name, d.Pos.Line, d.Pos.Offset, d.Message))
+	}
+	buf.Reset()
+	if err := env.Execute("filter.twig", buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "a!\nb!\nc"; buf.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, buf.String())
+	}
+	expected := `[filter.twig:2:3 the "filter" tag is deprecated, use the "apply" tag instead]`
+	if got := fmt.Sprint(seen); got != expected {
+		t.Errorf("expected deprecations %s, got %s", expected, got)
+	}
+}
+
+func TestDeprecatedTag(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"old.twig":    "{% deprecated 'use new.twig instead' %}old",
+		"child.twig":  "{% extends 'layout.twig' %}\n{% deprecated 'use ' ~ 'layout.twig' %}{% block content %}child{% endblock %}",
+		"layout.twig": "<{% block content %}{% endblock %}>",
+		"macros.twig": "{% macro old() %}\n{% deprecated 'use new() instead' %}old{% endmacro %}",
+		"index.twig":  "{% include 'old.twig' %}{% include 'child.twig' %}{% import 'macros.twig' as m %}{{ m.old() }}",
+	}})
+	var seen []string
+	env.DeprecationHandler = func(ctx Context, d Deprecation) {
+		seen = append(seen, fmt.Sprintf("%s:%d:%d %s", d.Name, d.Pos.Line, d.Pos.Offset, d.Message))
+	}
+	buf := &bytes.Buffer{}
+	if err := env.Execute("index.twig", buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "old<child>\nold"; buf.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, buf.String())
+	}
+	expected := "[old.twig:1:3 use new.twig instead child.twig:2:3 use layout.twig macros.twig:2:3 use new() instead]"
+	if got := fmt.Sprint(seen); got != expected {
+		t.Errorf("expected deprecations %s, got %s", expected, got)
+	}
+}
+
+func TestDeprecatedHelpers(t *testing.T) {
+	env := New(nil)
+	env.Functions["old_func"] = func(ctx Context, args ...Value) Value {
+		return "f"
+	}
+	env.Filters["old_filter"] = func(ctx Context, val Value, args ...Value) Value {
+		return val
+	}
+	env.Tests["old_test"] = func(ctx Context, val Value, args ...Value) bool {
+		return true
+	}
+	env.DeprecatedFunctions["old_func"] = "use new_func instead"
+	env.DeprecatedFilters["old_filter"] = ""
+	env.DeprecatedTests["old_test"] = "use new_test instead"
+
+	var seen []string
+	env.DeprecationHandler = func(ctx Context, d Deprecation) {
+		seen = append(seen, fmt.Sprintf("%d:%d %s", d.Pos.Line, d.Pos.Offset, d.Message))
+	}
+	buf := &bytes.Buffer{}
+	tpl := "{{ old_func() }}\n{{ 'a'|old_filter }}{% apply old_filter %}b{% endapply %}\n{{ 1 is old_test ? 'c' : 'd' }}"
+	if err := env.Execute(tpl, buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "f\nab\nc"; buf.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, buf.String())
+	}
+	expected := `[1:3 the "old_func" function is deprecated, use new_func instead ` +
+		`2:6 the "old_filter" filter is deprecated ` +
+		`2:29 the "old_filter" filter is deprecated ` +
+		`3:8 the "old_test" test is deprecated, use new_test instead]`
+	if got := fmt.Sprint(seen); got != expected {
+		t.Errorf("expected deprecations %s, got %s", expected, got)
+	}
+}
@@ -38,6 +38,154 @@ Another example, using a FilesystemLoader and responding to an HTTP request:
 	})
 	http.ListenAndServe(":80", nil)
 
+# Template caching
+
+By default, templates are loaded and parsed each time they are used. Set the
+Cache on an Env to reuse parsed templates instead:
+
+	env := stick.New(stick.NewFilesystemLoader(fsRoot))
+	env.Cache = stick.NewMemoryTemplateCache(100) // Holds up to 100 parsed templates.
+
+Loaders that implement CacheKeyLoader, such as FilesystemLoader and MemoryLoader,
+cause a cached template to be parsed again when its source changes.
+
+# Fragment caching
+
+The output of a cache tag is stored in the FragmentCache of the Env, if set,
+so its body is executed only when the cached output is missing or expired:
+
+	{% cache 'nav' ttl(300) tags(['nav']) %}{{ render_menu() }}{% endcache %}
+
+The ttl, in seconds, and tags are optional. Output cached without a ttl, or
+with a ttl of zero, never expires. Cached output can be invalidated by key or
+by tag:
+
+	cache := stick.NewMemoryFragmentCache(1000) // Holds up to 1000 fragments.
+	env.FragmentCache = cache
+	cache.InvalidateTags("nav")
+
+# Cancellation
+
+Use ExecuteContext to stop executing a template when a context.Context is done,
+such as when an HTTP request is cancelled:
+
+	err := env.ExecuteContext(r.Context(), "index.html.twig", w, vars)
+
+Functions and filters can access the context.Context through Context.Context.
+
+# Sandbox
+
+A SandboxExtension restricts the tags, filters, functions, methods and fields
+that untrusted templates may use:
+
+	policy := &stick.SecurityPolicy{
+		Tags:    []string{"if", "for"},
+		Filters: []string{"upper"},
+		Fields:  map[string][]string{"main.User": {"Name"}},
+	}
+	env.Register(stick.NewSandboxExtension(policy, false))
+
+Templates included within a sandbox tag are then checked against the policy,
+and an error wrapping a *SecurityError is returned on any violation:
+
+	{% sandbox %}{% include 'user_template.twig' %}{% endsandbox %}
+
+Pass true to NewSandboxExtension to sandbox every template.
+
+# Resource limits
+
+Set Limits on an Env to stop executing templates that use too many resources,
+such as a macro that calls itself forever:
+
+	env.Limits = stick.Limits{
+		MaxDepth:      50,      // Nested includes, parent templates and macro calls.
+		MaxIterations: 100000,  // Total for loop iterations.
+		MaxRangeSize:  10000,   // Elements created by the range operator, such as 1..10.
+		MaxOutput:     1 << 20, // Bytes written, including captured output.
+	}
+
+An error wrapping a *LimitError is returned when a limit is exceeded.
+
+# Errors
+
+Errors that occur while executing a template are returned as an *ExecutionError.
+It describes the template and position where the error occurred, and the chain of
+includes, embeds, parent templates and macro calls that led there:
+
+	var eerr *stick.ExecutionError
+	if errors.As(err, &eerr) {
+		log.Printf("%s:%d: %s", eerr.Name, eerr.Pos.Line, eerr.Err)
+	}
+
+Functions and filters report errors with Context.Fail, which stops execution
+once the function or filter returns:
+
+	env.Functions["load_user"] = func(ctx stick.Context, args ...stick.Value) stick.Value {
+		u, err := loadUser(stick.CoerceString(args[0]))
+		if err != nil {
+			ctx.Fail(err)
+			return nil
+		}
+		return u
+	}
+
+# Undefined values
+
+By default, undefined variables and attributes are treated as null. Set
+StrictVariables on an Env to return an error instead. An UndefinedHandler can be
+used to log undefined values, or substitute a different value:
+
+	env.UndefinedHandler = func(ctx stick.Context, u stick.Undefined) (stick.Value, error) {
+		log.Printf("undefined %s %q in %s", u.Kind, u.Name, ctx.Name())
+		return nil, nil
+	}
+
+# Deprecations
+
+A DeprecationHandler is called each time a template uses a deprecated feature,
+such as the filter tag, which is an alias of the apply tag:
+
+	env.DeprecationHandler = func(ctx stick.Context, d stick.Deprecation) {
+		log.Printf("%s:%d: %s", d.Name, d.Pos.Line, d.Message)
+	}
+
+Templates and macros can be marked as deprecated with the deprecated tag:
+
+	{% deprecated 'The "old.twig" template is deprecated, use "new.twig" instead.' %}
+
+User-defined functions, filters and tests are marked as deprecated by name,
+with an optional message:
+
+	env.DeprecatedFilters["old_filter"] = "use new_filter instead"
+
+# Arrow functions
+
+Templates can pass arrow functions to functions and filters:
+
+	{{ users|filter(u => u.Active)|map(u => u.Name)|join(', ') }}
+
+An arrow function is passed as an *Arrow, which can be called with Context.Call:
+
+	env.Filters["apply"] = func(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+		res, err := ctx.Call(args[0], val)
+		if err != nil {
+			return nil
+		}
+		return res
+	}
+
+# Named arguments
+
+Arguments can be passed to functions, filters and macros by name:
+
+	{{ forms.input(name='email', type='email') }}
+
+Named arguments are mapped to the position of the macro parameter with the same
+name. For user-defined functions and filters, declare the parameter names in
+FunctionParams and FilterParams:
+
+	env.FilterParams["number_format"] = []string{"decimal", "decimal_point", "thousand_sep"}
+
 # Types and values
 
 Any user value in Stick is represented by a stick.Value. There are three main types
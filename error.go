@@ -0,0 +1,76 @@
+package stick
+
+import (
+	"fmt"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// A CancelError is returned when execution stops because the context.Context
+// passed to ExecuteContext is done.
+type CancelError struct {
+	Err  error     // The error returned by the context, either context.Canceled or context.DeadlineExceeded.
+	Name string    // The name of the template being executed.
+	Pos  parse.Pos // The position where execution stopped.
+}
+
+func (e *CancelError) Error() string {
+	return fmt.Sprintf("execute: %s on line %d, column %d in %s", e.Err, e.Pos.Line, e.Pos.Offset, e.Name)
+}
+
+// Unwrap returns the error returned by the context.
+func (e *CancelError) Unwrap() error {
+	return e.Err
+}
+
+// A LimitError is returned when executing a template exceeds one of the Env's Limits.
+type LimitError struct {
+	Limit string    // A description of the exceeded limit, such as "loop iterations".
+	Max   int       // The configured maximum.
+	Name  string    // The name of the template being executed.
+	Pos   parse.Pos // The position where the limit was exceeded.
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("execute: exceeded limit of %d %s on line %d, column %d in %s", e.Max, e.Limit, e.Pos.Line, e.Pos.Offset, e.Name)
+}
+
+// A Frame describes an include, embed, parent template or macro call that led
+// to the template being executed.
+type Frame struct {
+	Kind string    // One of "include", "embed", "extends" or "macro".
+	Name string    // The name of the template containing the tag or macro call.
+	Pos  parse.Pos // The position of the tag or macro call.
+}
+
+// An ExecutionError describes an error that occurred while executing a template.
+//
+// On Go 1.13 and later, errors.As and errors.Is can be used to inspect the
+// underlying error.
+type ExecutionError struct {
+	Name  string    // The name of the template being executed.
+	Pos   parse.Pos // The position of the node that caused the error.
+	Stack []Frame   // The frames leading to the template, outermost first.
+	Err   error     // The underlying error.
+}
+
+func (e *ExecutionError) Error() string {
+	var msg string
+	switch e.Err.(type) {
+	case *CancelError, *SecurityError, *LimitError, parse.ParsingError:
+		// The underlying error already describes where it occurred.
+		msg = e.Err.Error()
+	default:
+		msg = fmt.Sprintf("execute: %s on line %d, column %d in %s", e.Err, e.Pos.Line, e.Pos.Offset, e.Name)
+	}
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		f := e.Stack[i]
+		msg += fmt.Sprintf(", from %s on line %d, column %d in %s", f.Kind, f.Pos.Line, f.Pos.Offset, f.Name)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
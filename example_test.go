@@ -31,7 +31,47 @@ func ExampleEnv_ExecuteSafe() {
 	if err != nil {
 		fmt.Println(err)
 	}
-	// Output: Undeclared filter "fakefilter"
+	// Output: execute: Undeclared filter "fakefilter" on line 1, column 18 in Hello, {{ 'world' | fakefilter }}!
+}
+
+// An example of loading a template once and executing it many times.
+func ExampleEnv_Load() {
+	env := stick.New(&stick.MemoryLoader{
+		Templates: map[string]string{
+			"hello.twig": `Hello, {{ name }}!`,
+		},
+	})
+
+	// Syntax errors are reported when the template is loaded.
+	tpl, err := env.Load("hello.twig")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, name := range []string{"World", "Tyler"} {
+		if err := tpl.Execute(os.Stdout, map[string]stick.Value{"name": name}); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Println()
+	}
+	// Output:
+	// Hello, World!
+	// Hello, Tyler!
+}
+
+func ExampleEnv_ExecuteBlock() {
+	env := stick.New(&stick.MemoryLoader{
+		Templates: map[string]string{
+			"base.twig":  `<title>{% block title %}Site{% endblock %}</title>{% block body %}{% endblock %}`,
+			"index.twig": `{% extends 'base.twig' %}{% block title %}{{ parent() }} - Home{% endblock %}`,
+		},
+	})
+
+	// Only the title block is rendered.
+	if err := env.ExecuteBlock("index.twig", "title", os.Stdout, nil); err != nil {
+		fmt.Println(err)
+	}
+	// Output: Site - Home
 }
 
 type exampleType struct{}
@@ -2,6 +2,7 @@ package stick
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tystuyfzand/stick/parse"
 )
@@ -29,12 +31,34 @@ type State struct {
 
 	localMacros map[string]*parse.MacroNode // Macros defined in the current template.
 
-	env   *Env        // The configured Stick environment.
-	scope *scopeStack // Handles execution scope.
+	env   *Env            // The configured Stick environment.
+	scope *scopeStack     // Handles execution scope.
+	ctx   context.Context // Cancellation of the execution.
+
+	sandboxed bool    // True if the template is restricted by the Env's SecurityPolicy.
+	usage     *usage  // Resources used by the execution.
+	frames    []Frame // Includes, embeds, parent templates and macro calls leading to the current template.
+
+	ignoreUndefined bool       // True if undefined values should be treated as null without error.
+	lastUndefined   *Undefined // The last undefined value treated as null.
+
+	failed error // Error reported by the function or filter being called.
 }
 
 // NewState creates a new template execution State, ready for use.
+//
+// The given ctx is copied; values set while executing the template do not
+// modify it.
 func NewState(name string, out io.Writer, ctx map[string]Value, env *Env) *State {
+	return newState(context.Background(), name, out, ctx, env)
+}
+
+// newState creates a new State that stops executing when c is done.
+func newState(c context.Context, name string, out io.Writer, ctx map[string]Value, env *Env) *State {
+	root := make(map[string]Value, len(ctx))
+	for k, v := range ctx {
+		root[k] = v
+	}
 	return &State{
 		out:  out,
 		node: nil,
@@ -48,7 +72,11 @@ func NewState(name string, out io.Writer, ctx map[string]Value, env *Env) *State
 		localMacros: make(map[string]*parse.MacroNode),
 
 		env:   env,
-		scope: &scopeStack{[]map[string]Value{ctx}},
+		scope: &scopeStack{[]map[string]Value{root}},
+		ctx:   c,
+
+		sandboxed: env.Sandboxed,
+		usage:     &usage{},
 	}
 }
 
@@ -78,6 +106,180 @@ func (s *State) Meta() ContextMetadata {
 	return s.meta
 }
 
+// Context returns the context.Context passed to ExecuteContext, or
+// context.Background if the template was executed without one.
+func (s *State) Context() context.Context {
+	return s.ctx
+}
+
+// load loads the named template, checking it against the Env's SecurityPolicy
+// if the State is sandboxed.
+func (s *State) load(name string) (*parse.Tree, error) {
+	tree, err := s.env.load(name)
+	if err != nil {
+		return nil, err
+	}
+	if s.sandboxed {
+		if err := s.env.Policy.checkTree(name, tree); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// loadTemplate loads the template with the given name, or returns the parsed
+// template if tpl is a *CompiledTemplate.
+func (s *State) loadTemplate(tpl Value) (string, *parse.Tree, error) {
+	t, ok := tpl.(*CompiledTemplate)
+	if !ok {
+		name := CoerceString(tpl)
+		tree, err := s.load(name)
+		return name, tree, err
+	}
+	if s.sandboxed {
+		if err := s.env.Policy.checkTree(t.name, t.tree); err != nil {
+			return "", nil, err
+		}
+	}
+	return t.name, t.tree, nil
+}
+
+// Include executes the named template, or a *CompiledTemplate, with the given
+// variables and returns its output.
+//
+// Include is used by functions that include other templates, and is subject
+// to the same cancellation and limits as the include tag.
+func (s *State) Include(tpl Value, vars map[string]Value) (string, error) {
+	var pos parse.Pos
+	if s.node != nil {
+		pos = s.node.Start()
+	}
+	if err := s.checkContext(pos); err != nil {
+		return "", err
+	}
+	if err := s.enter(pos); err != nil {
+		return "", err
+	}
+	defer s.leave()
+	name, tree, err := s.loadTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+	return s.includeTree(pos, name, tree, vars)
+}
+
+// Fail reports an error from a function or filter, which cannot return one.
+// Execution stops with err once the function or filter returns.
+func (s *State) Fail(err error) {
+	if s.failed == nil {
+		s.failed = err
+	}
+}
+
+// takeFailure returns and clears the error reported by Fail, if any. The
+// position of the call is given by pos.
+func (s *State) takeFailure(pos parse.Pos) error {
+	err := s.failed
+	s.failed = nil
+	if err == nil {
+		return nil
+	}
+	return s.locate(err, pos)
+}
+
+// locate sets the template name and position of a LimitError or
+// SecurityError that does not have one to the current template and pos.
+func (s *State) locate(err error, pos parse.Pos) error {
+	switch e := err.(type) {
+	case *LimitError:
+		if e.Name == "" {
+			e.Name, e.Pos = s.name, pos
+		}
+	case *SecurityError:
+		if e.Name == "" {
+			e.Name, e.Pos = s.name, pos
+		}
+	}
+	return err
+}
+
+// GetAttr returns the attribute attr of v, as the "." operator does. If the
+// State is sandboxed, methods and fields must be allowed by the Env's
+// SecurityPolicy.
+func (s *State) GetAttr(v Value, attr Value, args ...Value) (Value, error) {
+	var check attrCheck
+	if s.sandboxed {
+		check = s.env.Policy.checkAttr
+	}
+	res, err := getAttr(v, attr, check, args...)
+	if serr, ok := err.(*SecurityError); ok {
+		serr.Name = s.name
+		if s.node != nil {
+			serr.Pos = s.node.Start()
+		}
+	}
+	return res, err
+}
+
+// includeTree executes the given template with the given variables and
+// returns its output. The position of the include is given by pos.
+func (s *State) includeTree(pos parse.Pos, name string, tree *parse.Tree, vars map[string]Value) (string, error) {
+	s.pushFrame("include", pos)
+	si := s.newChild(name, vars)
+	s.popFrame()
+	buf := &bytes.Buffer{}
+	si.out = buf
+	si.blocks = append(si.blocks, tree.Blocks())
+	if err := si.Walk(tree.Root()); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// newChild creates a State for executing an included or embedded template.
+func (s *State) newChild(name string, ctx map[string]Value) *State {
+	si := newState(s.ctx, name, s.out, ctx, s.env)
+	si.sandboxed = s.sandboxed
+	si.usage = s.usage
+	si.frames = s.frames
+	return si
+}
+
+// pushFrame records entering an included, embedded or parent template, or
+// a macro, from the given position in the current template.
+func (s *State) pushFrame(kind string, pos parse.Pos) {
+	// Copy the frames, as they may be shared with other States.
+	frames := make([]Frame, len(s.frames), len(s.frames)+1)
+	copy(frames, s.frames)
+	s.frames = append(frames, Frame{kind, s.name, pos})
+}
+
+// popFrame removes the most recently pushed frame.
+func (s *State) popFrame() {
+	s.frames = s.frames[:len(s.frames)-1]
+}
+
+// wrapError returns err as an ExecutionError that occurred at the given node,
+// unless it already is one.
+func (s *State) wrapError(node parse.Node, err error) error {
+	if _, ok := err.(*ExecutionError); ok {
+		return err
+	}
+	frames := make([]Frame, len(s.frames))
+	copy(frames, s.frames)
+	return &ExecutionError{s.name, node.Start(), frames, err}
+}
+
+// checkContext returns a CancelError if the execution's context is done.
+func (s *State) checkContext(pos parse.Pos) error {
+	select {
+	case <-s.ctx.Done():
+		return &CancelError{s.ctx.Err(), s.name, pos}
+	default:
+		return nil
+	}
+}
+
 // noexport satisfies the Context interface.
 func (s *State) noexport() {}
 
@@ -198,39 +400,45 @@ func (s *State) getBlock(name string) *parse.BlockNode {
 	return nil
 }
 
-func (s *State) getParentBlock(name string) *parse.BlockNode {
-	rootFound := false
+// getParentBlock returns the next definition of the given block, after the
+// level where the block is defined.
+func (s *State) getParentBlock(current *parse.BlockNode) *parse.BlockNode {
+	currentFound := false
 	for _, blocks := range s.blocks {
-		if block, ok := blocks[name]; ok {
-			if rootFound {
+		if block, ok := blocks[current.Name]; ok {
+			if currentFound {
 				return block
 			}
-			rootFound = true
+			currentFound = block == current
 		}
 	}
 	return nil
 }
 
 // Walk is the main entry-point into template execution.
+//
+// Errors are returned as an *ExecutionError describing where the error occurred.
 func (s *State) Walk(node parse.Node) error {
+	if err := s.walk(node); err != nil {
+		return s.wrapError(node, err)
+	}
+	return nil
+}
+
+func (s *State) walk(node parse.Node) error {
 	switch node := node.(type) {
 	case *parse.ModuleNode:
-		if p := node.Parent; p != nil {
-			tplName, err := s.EvalExpr(p.Tpl)
-			if err != nil {
-				return err
-			}
-			name := CoerceString(tplName)
-			tree, err := s.env.load(name)
-			if err != nil {
+		if node.Parent != nil {
+			if err := s.enter(node.Parent.Start()); err != nil {
 				return err
 			}
+			defer s.leave()
+			s.pushFrame("extends", node.Parent.Start())
+			defer s.popFrame()
 			defer func(name string) {
 				s.name = name
 			}(s.name)
-			s.name = name
-			s.blocks = append(s.blocks, tree.Blocks())
-			err = s.walkChild(node.BodyNode)
+			tree, err := s.extend(node)
 			if err != nil {
 				return err
 			}
@@ -248,15 +456,13 @@ func (s *State) Walk(node parse.Node) error {
 		s.localMacros[node.Name] = node
 		return nil
 	case *parse.TextNode:
-		_, err := io.WriteString(s.out, node.Data)
-		return err
+		return s.write(node.Start(), node.Data)
 	case *parse.PrintNode:
 		v, err := s.EvalExpr(node.X)
 		if err != nil {
 			return err
 		}
-		_, err = io.WriteString(s.out, CoerceString(v))
-		return err
+		return s.write(node.Start(), CoerceString(v))
 	case *parse.BlockNode:
 		name := node.Name
 		if block := s.getBlock(name); block != nil {
@@ -286,25 +492,50 @@ func (s *State) Walk(node parse.Node) error {
 			return s.Walk(node.Else)
 		}
 	case *parse.IncludeNode:
+		if err := s.checkContext(node.Start()); err != nil {
+			return err
+		}
+		if err := s.enter(node.Start()); err != nil {
+			return err
+		}
+		defer s.leave()
 		tpl, ctx, err := s.walkIncludeNode(node)
 		if err != nil {
 			return err
 		}
-		err = execute(tpl, s.out, ctx, s.env)
+		name, tree, err := s.loadTemplate(tpl)
+		if err != nil {
+			return err
+		}
+		s.pushFrame("include", node.Start())
+		si := s.newChild(name, ctx)
+		s.popFrame()
+		si.blocks = append(si.blocks, tree.Blocks())
+		err = si.Walk(tree.Root())
 		if err != nil {
 			return err
 		}
 	case *parse.EmbedNode:
+		if err := s.checkContext(node.Start()); err != nil {
+			return err
+		}
+		if err := s.enter(node.Start()); err != nil {
+			return err
+		}
+		defer s.leave()
 		tpl, ctx, err := s.walkIncludeNode(node.IncludeNode)
 		if err != nil {
 			return err
 		}
-		si := NewState(tpl, s.out, ctx, s.env)
-		tree, err := s.env.load(tpl)
+		name, tree, err := s.loadTemplate(tpl)
 		if err != nil {
 			return err
 		}
-		si.blocks = append(s.blocks, node.Blocks, tree.Blocks())
+		s.pushFrame("embed", node.Start())
+		si := s.newChild(name, ctx)
+		s.popFrame()
+		si.blocks = make([]map[string]*parse.BlockNode, 0, len(s.blocks)+2)
+		si.blocks = append(append(si.blocks, s.blocks...), node.Blocks, tree.Blocks())
 		err = si.Walk(tree.Root())
 		if err != nil {
 			return err
@@ -319,6 +550,29 @@ func (s *State) Walk(node parse.Node) error {
 		return s.walkDoNode(node)
 	case *parse.FilterNode:
 		return s.walkFilterNode(node)
+	case *parse.ApplyNode:
+		return s.walkApplyNode(node)
+	case *parse.WithNode:
+		return s.walkWithNode(node)
+	case *parse.AutoEscapeNode:
+		return s.Walk(node.Body)
+	case *parse.SpacelessNode:
+		return s.walkSpacelessNode(node)
+	case *parse.CacheNode:
+		return s.walkCacheNode(node)
+	case *parse.DeprecatedNode:
+		msg, err := s.EvalExpr(node.Message)
+		if err != nil {
+			return err
+		}
+		s.deprecated(node.Start(), CoerceString(msg))
+	case *parse.SandboxNode:
+		prev := s.sandboxed
+		s.sandboxed = true
+		defer func() {
+			s.sandboxed = prev
+		}()
+		return s.Walk(node.Body)
 	case *parse.ImportNode:
 		return s.walkImportNode(node)
 	case *parse.FromNode:
@@ -331,6 +585,64 @@ func (s *State) Walk(node parse.Node) error {
 	return nil
 }
 
+// extend loads the parent of the given child module, making the parent's blocks
+// and any blocks included by the child available. The name of the State is set
+// to the name of the parent.
+func (s *State) extend(node *parse.ModuleNode) (*parse.Tree, error) {
+	tplName, err := s.EvalExpr(node.Parent.Tpl)
+	if err != nil {
+		return nil, err
+	}
+	name := CoerceString(tplName)
+	tree, err := s.load(name)
+	if err != nil {
+		return nil, err
+	}
+	s.blocks = append(s.blocks, tree.Blocks())
+	err = s.walkChild(node.BodyNode)
+	if err != nil {
+		return nil, err
+	}
+	s.name = name
+	return tree, nil
+}
+
+// walkBlock resolves the inheritance chain of the given module in the same way
+// as Walk, but only executes the named block.
+func (s *State) walkBlock(node *parse.ModuleNode, name string) error {
+	if node.Parent != nil {
+		if err := s.enter(node.Parent.Start()); err != nil {
+			return err
+		}
+		defer s.leave()
+		s.pushFrame("extends", node.Parent.Start())
+		defer s.popFrame()
+		defer func(name string) {
+			s.name = name
+		}(s.name)
+		tree, err := s.extend(node)
+		if err != nil {
+			return err
+		}
+		return s.walkBlock(tree.Root(), name)
+	}
+	// The root template's definitions would be executed before any of its
+	// blocks, so they are executed here too, without any output.
+	for _, c := range node.All() {
+		switch c.(type) {
+		case *parse.UseNode, *parse.ImportNode, *parse.FromNode, *parse.MacroNode, *parse.SetNode, *parse.DoNode:
+			if err := s.Walk(c); err != nil {
+				return err
+			}
+		}
+	}
+	block := s.getBlock(name)
+	if block == nil {
+		return errors.New("Unable to locate block \"" + name + "\"")
+	}
+	return s.Walk(block)
+}
+
 // walkChild only executes a subset of nodes, intended to be used on child templates.
 func (s *State) walkChild(node parse.Node) error {
 	switch node := node.(type) {
@@ -343,6 +655,10 @@ func (s *State) walkChild(node parse.Node) error {
 		}
 	case *parse.UseNode:
 		return s.walkUseNode(node)
+	case *parse.DeprecatedNode:
+		// A child template may be deprecated, even though the rest of its
+		// body is not executed.
+		return s.Walk(node)
 	default:
 		// No need to handle other nodes. This function only populates blocks from a
 		// referenced template (in a use statement) and does not actually execute anything.
@@ -358,6 +674,12 @@ func (s *State) walkForNode(node *parse.ForNode) error {
 	kn := node.Key
 	vn := node.Val
 	ct, err := Iterate(res, func(k Value, v Value, l Loop) (bool, error) {
+		if err := s.checkContext(node.Start()); err != nil {
+			return true, err
+		}
+		if err := s.iterate(node.Start()); err != nil {
+			return true, err
+		}
 		s.scope.push()
 		defer s.scope.pop()
 
@@ -401,19 +723,18 @@ func (s *State) walkForNode(node *parse.ForNode) error {
 }
 
 // Method walkInclude determines the necessary parameters for including or embedding a template.
-func (s *State) walkIncludeNode(node *parse.IncludeNode) (tpl string, ctx map[string]Value, err error) {
+func (s *State) walkIncludeNode(node *parse.IncludeNode) (tpl Value, ctx map[string]Value, err error) {
 	ctx = make(map[string]Value)
-	v, err := s.EvalExpr(node.Tpl)
+	tpl, err = s.EvalExpr(node.Tpl)
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	}
-	tpl = CoerceString(v)
 	var with Value
 	if n := node.With; n != nil {
 		with, err = s.EvalExpr(n)
 		// TODO: Assert "with" is a hash?
 		if err != nil {
-			return "", nil, err
+			return nil, nil, err
 		}
 	}
 	if !node.Only {
@@ -435,17 +756,25 @@ func (s *State) walkUseNode(node *parse.UseNode) error {
 		return err
 	}
 	tpl := CoerceString(v)
-	tree, err := s.env.load(tpl)
+	tree, err := s.load(tpl)
 	if err != nil {
 		return err
 	}
 	blocks := tree.Blocks()
-	for orig, alias := range node.Aliases {
-		v, ok := blocks[orig]
-		if !ok {
-			return errors.New("Unable to locate block with name \"" + orig + "\"")
+	if len(node.Aliases) > 0 {
+		// The parsed tree is shared, so aliases are added to a copy of its blocks.
+		aliased := make(map[string]*parse.BlockNode, len(blocks)+len(node.Aliases))
+		for name, v := range blocks {
+			aliased[name] = v
 		}
-		blocks[alias] = v
+		for orig, alias := range node.Aliases {
+			v, ok := blocks[orig]
+			if !ok {
+				return errors.New("Unable to locate block with name \"" + orig + "\"")
+			}
+			aliased[alias] = v
+		}
+		blocks = aliased
 	}
 	l := len(s.blocks)
 	lb := s.blocks[l-1]
@@ -505,16 +834,158 @@ func (s *State) walkFilterNode(node *parse.FilterNode) error {
 	if err != nil {
 		return err
 	}
-	val := string(buf.Bytes())
+	var val Value = buf.String()
 	for _, v := range node.Filters {
-		f, ok := s.env.Filters[v]
-		if !ok {
-			return errors.New("undefined filter \"" + v + "\".")
+		val, err = s.applyFilter(node, v, val, nil)
+		if err != nil {
+			return err
 		}
-		val = CoerceString(f(s, val))
 	}
-	io.WriteString(prevBuf, val)
-	return nil
+	s.out = prevBuf
+	return s.write(node.Start(), CoerceString(val))
+}
+
+func (s *State) walkApplyNode(node *parse.ApplyNode) error {
+	switch node.Tag {
+	case "filter":
+		s.deprecated(node.Start(), `the "filter" tag is deprecated, use the "apply" tag instead`)
+	}
+	prevBuf := s.out
+	defer func() {
+		s.out = prevBuf
+	}()
+	buf := &bytes.Buffer{}
+	s.out = buf
+	err := s.Walk(node.Body)
+	if err != nil {
+		return err
+	}
+	var val Value = buf.String()
+	for _, f := range node.Filters {
+		val, err = s.applyFilter(f, f.Name, val, f.Args)
+		if err != nil {
+			return s.wrapError(f, err)
+		}
+	}
+	s.out = prevBuf
+	return s.write(node.Start(), CoerceString(val))
+}
+
+var spacelessPattern = regexp.MustCompile(`>\s+<`)
+
+// Spaceless returns s with whitespace between HTML tags, and leading and
+// trailing whitespace, removed. Whitespace within text is left as is.
+//
+// Spaceless implements both the spaceless tag and the spaceless filter in
+// package twig/filter.
+func Spaceless(s string) string {
+	return spacelessPattern.ReplaceAllString(strings.TrimSpace(s), "><")
+}
+
+// walkSpacelessNode writes the output of the body with Spaceless applied.
+func (s *State) walkSpacelessNode(node *parse.SpacelessNode) error {
+	prevBuf := s.out
+	defer func() {
+		s.out = prevBuf
+	}()
+	buf := &bytes.Buffer{}
+	s.out = buf
+	if err := s.Walk(node.Body); err != nil {
+		return err
+	}
+	s.out = prevBuf
+	return s.write(node.Start(), Spaceless(buf.String()))
+}
+
+// walkCacheNode writes the cached output of the cache tag, if any. Otherwise,
+// the body is executed and its output is stored in the Env's FragmentCache.
+func (s *State) walkCacheNode(node *parse.CacheNode) error {
+	cache := s.env.FragmentCache
+	if cache == nil {
+		return s.Walk(node.Body)
+	}
+	k, err := s.EvalExpr(node.Key)
+	if err != nil {
+		return err
+	}
+	key := CoerceString(k)
+	if val, ok := cache.Get(key); ok {
+		return s.write(node.Start(), val)
+	}
+
+	var ttl time.Duration
+	if node.TTL != nil {
+		v, err := s.EvalExpr(node.TTL)
+		if err != nil {
+			return err
+		}
+		ttl = time.Duration(CoerceNumber(v) * float64(time.Second))
+	}
+	var tags []string
+	if node.Tags != nil {
+		v, err := s.EvalExpr(node.Tags)
+		if err != nil {
+			return err
+		}
+		if IsIterable(v) {
+			_, err = Iterate(v, func(k, v Value, l Loop) (bool, error) {
+				tags = append(tags, CoerceString(v))
+				return false, nil
+			})
+			if err != nil {
+				return s.wrapError(node.Tags, err)
+			}
+		} else {
+			tags = []string{CoerceString(v)}
+		}
+	}
+
+	prevBuf := s.out
+	defer func() {
+		s.out = prevBuf
+	}()
+	buf := &bytes.Buffer{}
+	s.out = buf
+	err = s.Walk(node.Body)
+	if err != nil {
+		return err
+	}
+	val := buf.String()
+	cache.Set(key, val, ttl, tags)
+	s.out = prevBuf
+	return s.write(node.Start(), val)
+}
+
+// walkWithNode executes the body of the with tag in a new scope. Variables
+// set in the body, including those from the enclosing scope, are not visible
+// after the tag.
+func (s *State) walkWithNode(node *parse.WithNode) error {
+	vars := make(map[string]Value)
+	if node.With != nil {
+		with, err := s.EvalExpr(node.With)
+		if err != nil {
+			return err
+		}
+		if !IsMap(with) {
+			return s.wrapError(node.With, fmt.Errorf(`variables passed to the "with" tag must be a hash, got %T`, with))
+		}
+		_, err = Iterate(with, func(k, v Value, l Loop) (bool, error) {
+			vars[CoerceString(k)] = v
+			return false, nil
+		})
+		if err != nil {
+			return s.wrapError(node.With, err)
+		}
+	}
+	defer func(scope *scopeStack) {
+		s.scope = scope
+	}(s.scope)
+	if node.Only {
+		s.scope = &scopeStack{[]map[string]Value{vars}}
+	} else {
+		s.scope = &scopeStack{[]map[string]Value{s.scope.All(), vars}}
+	}
+	return s.Walk(node.Body)
 }
 
 func (s *State) walkImportNode(node *parse.ImportNode) error {
@@ -522,7 +993,7 @@ func (s *State) walkImportNode(node *parse.ImportNode) error {
 	if err != nil {
 		return err
 	}
-	tree, err := s.env.load(CoerceString(tpl))
+	tree, err := s.load(CoerceString(tpl))
 	if err != nil {
 		return err
 	}
@@ -539,7 +1010,7 @@ func (s *State) walkFromNode(node *parse.FromNode) error {
 	if err != nil {
 		return err
 	}
-	tree, err := s.env.load(CoerceString(tpl))
+	tree, err := s.load(CoerceString(tpl))
 	if err != nil {
 		return err
 	}
@@ -555,7 +1026,17 @@ func (s *State) walkFromNode(node *parse.FromNode) error {
 }
 
 // EvalExpr evaluates the given expression, returning a Value or error.
-func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
+//
+// Errors are returned as an *ExecutionError describing where the error occurred.
+func (s *State) EvalExpr(exp parse.Expr) (Value, error) {
+	v, err := s.evalExpr(exp)
+	if err != nil {
+		return nil, s.wrapError(exp, err)
+	}
+	return v, nil
+}
+
+func (s *State) evalExpr(exp parse.Expr) (v Value, err error) {
 	switch exp := exp.(type) {
 	case *parse.NullExpr:
 		return nil, nil
@@ -566,10 +1047,14 @@ func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
 			return s.self(), nil
 		}
 		if val, ok := s.scope.Get(exp.Name); ok {
-			v = val
-		} else {
-			e = errors.New("undefined variable \"" + exp.Name + "\"")
+			return val, nil
 		}
+		return s.undefined(Undefined{
+			Kind: "variable",
+			Name: exp.Name,
+			Pos:  exp.Start(),
+			Err:  errors.New("undefined variable \"" + exp.Name + "\""),
+		})
 	case *parse.NumberExpr:
 		num, err := strconv.ParseFloat(exp.Value, 64)
 		if err != nil {
@@ -595,7 +1080,22 @@ func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
 			return -CoerceNumber(in), nil
 		}
 	case *parse.BinaryExpr:
-		left, err := s.EvalExpr(exp.Left)
+		if exp.Op == parse.OpBinaryNullCoalesce {
+			// The right side is only evaluated if the left side is undefined or null.
+			left, err := s.evalDefault(exp.Left)
+			if err != nil || left != nil {
+				return left, err
+			}
+			return s.EvalExpr(exp.Right)
+		}
+		var left Value
+		var err error
+		if t, ok := exp.Right.(*parse.TestExpr); ok && t.Name == "defined" {
+			// Like Twig, the operand of the defined test may be undefined.
+			left, err = s.evalDefined(exp.Left)
+		} else {
+			left, err = s.EvalExpr(exp.Left)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -662,9 +1162,17 @@ func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
 			return CoerceNumber(left) < CoerceNumber(right), nil
 		case parse.OpBinaryRange:
 			l, r := CoerceNumber(left), CoerceNumber(right)
-			res := make([]float64, uint(math.Ceil(r-l))+1)
-			for i, k := 0, l; k <= r; i, k = i+1, k+1 {
-				res[i] = k
+			n, err := s.env.Limits.RangeSize(l, r, 1)
+			if err != nil {
+				return nil, s.locate(err, exp.Start())
+			}
+			step := 1.0
+			if r < l {
+				step = -1
+			}
+			res := make([]float64, n)
+			for i := range res {
+				res[i] = l + float64(i)*step
 			}
 			return res, nil
 		case parse.OpBinaryBitwiseAnd:
@@ -693,18 +1201,13 @@ func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
 		if err != nil {
 			return nil, err
 		}
-		exargs := exp.Args
-		args := make([]Value, len(exargs))
-		for k, e := range exargs {
-			v, err := s.EvalExpr(e)
-			if err != nil {
-				return nil, err
-			}
-			args[k] = v
-		}
 		if _, ok := c.(selfValue); ok {
 			if macro, ok := s.localMacros[CoerceString(k)]; ok {
-				return s.callMacro(macroDef{macro}, args...)
+				args, err := s.evalArgs("macro", macro.Name, macro.Args, exp.Args)
+				if err != nil {
+					return nil, err
+				}
+				return s.callMacro(exp.Start(), macroDef{macro}, args...)
 			}
 			// no locally-defined macro defined with the given name, but the
 			// `_self` variable contains other special values such as `templateName`.
@@ -712,24 +1215,48 @@ func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
 		}
 		if set, ok := c.(macroSet); ok {
 			if macro, ok := set.defs[CoerceString(k)]; ok {
-				return s.callMacro(macro, args...)
+				args, err := s.evalArgs("macro", macro.Name, macro.Args, exp.Args)
+				if err != nil {
+					return nil, err
+				}
+				return s.callMacro(exp.Start(), macro, args...)
 			}
-			return nil, errors.New("undefined macro: " + CoerceString(k))
+			return s.undefined(Undefined{
+				Kind:  "macro",
+				Name:  CoerceString(k),
+				Value: c,
+				Pos:   exp.Start(),
+				Err:   errors.New("undefined macro: " + CoerceString(k)),
+			})
 		}
-		v, err = GetAttr(c, k, args...)
+		args, err := s.evalArgs("method", CoerceString(k), nil, exp.Args)
 		if err != nil {
-			e = err
+			return nil, err
+		}
+		v, err = s.GetAttr(c, k, args...)
+		if err != nil {
+			if serr, ok := err.(*SecurityError); ok {
+				serr.Pos = exp.Start()
+				return nil, serr
+			}
+			if _, ok := err.(*undefinedAttrError); !ok {
+				// Errors calling a method are not undefined attributes.
+				return nil, err
+			}
+			return s.undefined(Undefined{
+				Kind:  "attribute",
+				Name:  CoerceString(k),
+				Value: c,
+				Pos:   exp.Start(),
+				Err:   err,
+			})
 		}
 	case *parse.TestExpr:
 		if tfn, ok := s.env.Tests[exp.Name]; ok {
-			eargs := exp.Args
-			args := make([]Value, len(eargs))
-			for i, e := range eargs {
-				v, err := s.EvalExpr(e)
-				if err != nil {
-					return nil, err
-				}
-				args[i] = v
+			s.deprecatedUse("test", exp.Name, s.env.DeprecatedTests, exp.Start())
+			args, err := s.evalArgs("test", exp.Name, nil, exp.Args)
+			if err != nil {
+				return nil, err
 			}
 			return func(v Value) bool {
 				return tfn(s, v, args...)
@@ -778,6 +1305,12 @@ func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
 		}
 		return vals, nil
 
+	case *parse.NamedArgExpr:
+		return nil, fmt.Errorf(`unexpected named argument "%s"`, exp.Name)
+
+	case *parse.ArrowExpr:
+		return s.newArrow(exp), nil
+
 	default:
 		return nil, fmt.Errorf("unable to evaluate unsupported Expr type: %T (bug?)", exp)
 	}
@@ -785,6 +1318,26 @@ func (s *State) EvalExpr(exp parse.Expr) (v Value, e error) {
 	return v, nil
 }
 
+// renderBlock returns the output of the given block, as rendered by the
+// parent and block functions. The position of the call is given by pos.
+func (s *State) renderBlock(pos parse.Pos, blk *parse.BlockNode) (Value, error) {
+	if err := s.enter(pos); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	defer func(out io.Writer, current *parse.BlockNode) {
+		s.out = out
+		s.current = current
+	}(s.out, s.current)
+	buf := &bytes.Buffer{}
+	s.out = buf
+	s.current = blk
+	if err := s.Walk(blk.Body); err != nil {
+		return nil, err
+	}
+	return buf.String(), nil
+}
+
 func (s *State) evalFunction(exp *parse.FuncExpr) (Value, error) {
 	fnName := exp.Name
 	switch fnName {
@@ -793,15 +1346,8 @@ func (s *State) evalFunction(exp *parse.FuncExpr) (Value, error) {
 			return nil, errors.New("not inside a block!")
 		}
 		name := s.current.Name
-		if blk := s.getParentBlock(name); blk != nil {
-			pout := s.out
-			buf := &bytes.Buffer{}
-			s.out = buf
-			if err := s.Walk(blk.Body); err != nil {
-				return nil, err
-			}
-			s.out = pout
-			return buf.String(), nil
+		if blk := s.getParentBlock(s.current); blk != nil {
+			return s.renderBlock(exp.Start(), blk)
 		}
 		return nil, errors.New("Unable to locate block \"" + name + "\"")
 	case "block":
@@ -815,65 +1361,141 @@ func (s *State) evalFunction(exp *parse.FuncExpr) (Value, error) {
 		}
 		name := CoerceString(val)
 		if blk := s.getBlock(name); blk != nil {
-			pout := s.out
-			buf := &bytes.Buffer{}
-			s.out = buf
-			err = s.Walk(blk.Body)
-			if err != nil {
-				return nil, err
-			}
-			s.out = pout
-			return buf.String(), nil
+			return s.renderBlock(exp.Start(), blk)
 		}
 		return nil, errors.New("Unable to locate block \"" + name + "\"")
 	}
 	if macro, ok := s.macros[fnName]; ok {
-		eargs := exp.Args
-		args := make([]Value, len(eargs))
-		for i, e := range eargs {
-			v, err := s.EvalExpr(e)
-			if err != nil {
-				return nil, err
-			}
-			args[i] = v
+		args, err := s.evalArgs("macro", fnName, macro.Args, exp.Args)
+		if err != nil {
+			return nil, err
 		}
-		return s.callMacro(macroDef{macro}, args...)
+		return s.callMacro(exp.Start(), macroDef{macro}, args...)
 	}
 	if fn, ok := s.env.Functions[fnName]; ok {
-		eargs := exp.Args
-		args := make([]Value, len(eargs))
-		for i, e := range eargs {
+		if s.sandboxed {
+			if err := s.env.Policy.checkFunction(fnName); err != nil {
+				serr := err.(*SecurityError)
+				serr.Name, serr.Pos = s.name, exp.Start()
+				return nil, serr
+			}
+		}
+		s.deprecatedUse("function", fnName, s.env.DeprecatedFunctions, exp.Start())
+		args, err := s.evalArgs("function", fnName, s.env.FunctionParams[fnName], exp.Args)
+		if err != nil {
+			return nil, err
+		}
+		defer func(node parse.Node) {
+			s.node = node
+		}(s.node)
+		s.node = exp
+		v := fn(s, args...)
+		if err := s.takeFailure(exp.Start()); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, errors.New("Undeclared function \"" + fnName + "\"")
+}
+
+// A skippedArg marks a macro argument that was skipped by passing later
+// arguments by name, so that its default value is used.
+type skippedArg struct{}
+
+// evalArgs evaluates the arguments passed to the named function, filter,
+// macro, method or test. Named arguments are placed at the position of the
+// parameter with the same name. Skipped arguments are nil, or for macros,
+// their default value.
+func (s *State) evalArgs(kind, name string, params []string, exprs []parse.Expr) ([]Value, error) {
+	var skipped Value
+	if kind == "macro" {
+		skipped = skippedArg{}
+	}
+	args := make([]Value, 0, len(exprs))
+	set := make(map[int]bool, len(exprs))
+	for _, e := range exprs {
+		na, ok := e.(*parse.NamedArgExpr)
+		if !ok {
 			v, err := s.EvalExpr(e)
 			if err != nil {
 				return nil, err
 			}
-			args[i] = v
+			set[len(args)] = true
+			args = append(args, v)
+			continue
+		}
+		i := -1
+		for j, p := range params {
+			if p == na.Name {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, s.wrapError(na, fmt.Errorf(`unknown argument "%s" for %s "%s"`, na.Name, kind, name))
+		}
+		if set[i] {
+			return nil, s.wrapError(na, fmt.Errorf(`argument "%s" for %s "%s" is defined twice`, na.Name, kind, name))
+		}
+		v, err := s.EvalExpr(na.Value)
+		if err != nil {
+			return nil, err
 		}
-		return fn(s, args...), nil
+		for len(args) <= i {
+			args = append(args, skipped)
+		}
+		set[i] = true
+		args[i] = v
 	}
-	return nil, errors.New("Undeclared function \"" + fnName + "\"")
+	return args, nil
 }
 
 func (s *State) evalFilter(exp *parse.FilterExpr) (Value, error) {
 	ftName := exp.Name
-	if fn, ok := s.env.Filters[ftName]; ok {
+	if _, ok := s.env.Filters[ftName]; ok {
 		eargs := exp.Args
 		if len(eargs) == 0 {
 			return nil, errors.New("Filter call must receive at least one argument")
 		}
-		args := make([]Value, len(eargs))
-		for i, e := range eargs {
-			v, err := s.EvalExpr(e)
-			if err != nil {
-				return nil, err
-			}
-			args[i] = v
+		var val Value
+		var err error
+		if ftName == "default" {
+			// Like Twig, an undefined value passed to the default filter is not an error.
+			val, err = s.evalDefault(eargs[0])
+		} else {
+			val, err = s.EvalExpr(eargs[0])
+		}
+		if err != nil {
+			return nil, err
 		}
-		return fn(s, args[0], args[1:]...), nil
+		return s.applyFilter(exp, ftName, val, eargs[1:])
 	}
 	return nil, errors.New("Undeclared filter \"" + ftName + "\"")
 }
 
+// applyFilter calls the named filter with val and the given arguments. The
+// filter is applied by the given node.
+func (s *State) applyFilter(node parse.Node, name string, val Value, argExprs []parse.Expr) (Value, error) {
+	fn, ok := s.env.Filters[name]
+	if !ok {
+		return nil, errors.New("Undeclared filter \"" + name + "\"")
+	}
+	s.deprecatedUse("filter", name, s.env.DeprecatedFilters, node.Start())
+	args, err := s.evalArgs("filter", name, s.env.FilterParams[name], argExprs)
+	if err != nil {
+		return nil, err
+	}
+	defer func(node parse.Node) {
+		s.node = node
+	}(s.node)
+	s.node = node
+	v := fn(s, val, args...)
+	if err := s.takeFailure(node.Start()); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
 type macroDef struct {
 	*parse.MacroNode
 }
@@ -882,16 +1504,21 @@ type macroSet struct {
 	defs map[string]macroDef
 }
 
-func (s *State) callMacro(macro macroDef, args ...Value) (Value, error) {
+// callMacro executes the given macro, returning its output. The position of
+// the call is given by pos.
+func (s *State) callMacro(pos parse.Pos, macro macroDef, args ...Value) (Value, error) {
+	if err := s.checkContext(pos); err != nil {
+		return nil, err
+	}
+	if err := s.enter(pos); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	s.pushFrame("macro", pos)
+	defer s.popFrame()
+	defer s.isolateUndefined()()
 	s.scope.push()
 	defer s.scope.pop()
-	for i, name := range macro.Args {
-		if i >= len(args) {
-			s.scope.setLocal(name, nil)
-		} else {
-			s.scope.setLocal(name, args[i])
-		}
-	}
 	defer func(buf io.Writer) {
 		s.out = buf
 	}(s.out)
@@ -903,6 +1530,28 @@ func (s *State) callMacro(macro macroDef, args ...Value) (Value, error) {
 		}(s.name)
 		s.name = macro.Origin
 	}
+	for i, name := range macro.Args {
+		var v Value = skippedArg{}
+		if i < len(args) {
+			v = args[i]
+		}
+		if _, ok := v.(skippedArg); ok {
+			// Default values are evaluated each time the macro is called.
+			v = nil
+			if def, ok := macro.Defaults[name]; ok {
+				var err error
+				if v, err = s.EvalExpr(def); err != nil {
+					return nil, err
+				}
+			}
+		}
+		s.scope.setLocal(name, v)
+	}
+	varargs := []Value{}
+	if len(args) > len(macro.Args) {
+		varargs = append(varargs, args[len(macro.Args):]...)
+	}
+	s.scope.setLocal("varargs", varargs)
 	err := s.Walk(macro.Body)
 	if err != nil {
 		return nil, err
@@ -911,35 +1560,80 @@ func (s *State) callMacro(macro macroDef, args ...Value) (Value, error) {
 }
 
 // execute kicks off execution of the given template.
-func execute(name string, out io.Writer, ctx map[string]Value, env *Env) error {
-	if ctx == nil {
-		ctx = make(map[string]Value)
-	}
-	s := NewState(name, out, ctx, env)
-	tree, err := s.env.load(name)
+func execute(c context.Context, name string, out io.Writer, ctx map[string]Value, env *Env) error {
+	tree, err := env.load(name)
 	if err != nil {
 		return err
 	}
+	return executeTree(c, name, tree, out, ctx, env)
+}
+
+// executeBlock executes only the named block of an already parsed template.
+func executeBlock(c context.Context, name string, tree *parse.Tree, block string, out io.Writer, ctx map[string]Value, env *Env) error {
+	s := newState(c, name, out, ctx, env)
+	s.blocks = append(s.blocks, tree.Blocks())
+	return s.walkBlock(tree.Root(), block)
+}
+
+// executeTree executes an already parsed template.
+func executeTree(c context.Context, name string, tree *parse.Tree, out io.Writer, ctx map[string]Value, env *Env) error {
+	s := newState(c, name, out, ctx, env)
 	s.blocks = append(s.blocks, tree.Blocks())
-	err = s.Walk(tree.Root())
+	err := s.Walk(tree.Root())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Method load attempts to load and parse the given template.
+// Method load attempts to load and parse the given template, using the
+// configured TemplateCache if one exists.
 func (env *Env) load(name string) (*parse.Tree, error) {
-	tpl, err := env.Loader.Load(name)
+	loader := env.Loader
+	if env.Cache == nil {
+		return env.parse(loader, name)
+	}
+	key := ""
+	if l, ok := loader.(CacheKeyLoader); ok {
+		var err error
+		key, err = l.CacheKey(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tree, ok := env.Cache.Get(name, key); ok {
+		return tree, nil
+	}
+	tree, err := env.parse(loader, name)
 	if err != nil {
 		return nil, err
 	}
-	tree := parse.NewNamedTree(name, tpl.Contents())
+	env.Cache.Set(name, key, tree)
+	return tree, nil
+}
+
+// Method parse loads and parses the given template.
+func (env *Env) parse(loader Loader, name string) (*parse.Tree, error) {
+	tpl, err := loader.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	r := tpl.Contents()
+	if c, ok := r.(io.Closer); ok {
+		// Templates loaded from files hold an open file until closed.
+		defer c.Close()
+	}
+	tree := parse.NewNamedTree(name, r)
 	tree.Visitors = append(tree.Visitors, env.Visitors...)
 	tree.Parsers = env.Parsers
 	err = tree.Parse()
 	if err != nil {
 		return nil, err
 	}
+	if env.Sandboxed {
+		if err := env.Policy.checkTree(name, tree); err != nil {
+			return nil, err
+		}
+	}
 	return tree, nil
 }
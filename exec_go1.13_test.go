@@ -0,0 +1,32 @@
+//go:build go1.13
+// +build go1.13
+
+package stick
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExecutionErrorAs(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"index.twig": "{% include 'loop.twig' %}",
+		"loop.twig":  "{% for i in 1..10 %}{% endfor %}",
+	}})
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := env.ExecuteContext(c, "index.twig", &bytes.Buffer{}, nil)
+	var eerr *ExecutionError
+	if !errors.As(err, &eerr) || eerr.Name != "index.twig" {
+		t.Errorf("expected ExecutionError in index.twig, got %#v", err)
+	}
+	var cerr *CancelError
+	if !errors.As(err, &cerr) {
+		t.Errorf("expected CancelError, got %#v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %#v", err)
+	}
+}
@@ -2,9 +2,12 @@ package stick
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/tystuyfzand/stick/parse"
@@ -66,6 +69,8 @@ var tests = []execTest{
 	newExecTest("Chained attributes", `{{ entity.attr.Name }}`, expect(`Tyler`), withContext(map[string]Value{"entity": map[string]Value{"attr": struct{ Name string }{"Tyler"}}})),
 	newExecTest("Attribute method call", `{{ entity.Name('lower') }}`, expect(`lowerJohnny`), withContext(map[string]Value{"entity": &fakePerson{"Johnny"}})),
 	newExecTest("For loop", `{% for i in 1..3 %}{{ i }}{% endfor %}`, expect(`123`)),
+	newExecTest("For loop descending range", `{% for i in 3..1 %}{{ i }}{% endfor %}`, expect(`321`)),
+	newExecTest("For loop fractional range", `{% for i in 1..2.5 %}{{ i }}{% endfor %}`, expect(`12`)),
 	newExecTest(
 		"For loop with inner loop",
 		`{% for i in test %}{% for j in i %}{{ j }}{{ loop.index }}{{ loop.parent.index }}{% if loop.first %},{% endif %}{% if loop.last %};{% endif %}{% endfor %}{% if loop.first %}f{% endif %}{% if loop.last %}l{% endif %}:{% endfor %}`,
@@ -109,6 +114,34 @@ var tests = []execTest{
 		`{% extends '{% block message %}{% endblock %}' %}{% use '{% block message %}Hello{% endblock %}' with message as base_message %}{% block message %}{{ block('base_message') }}, World!{% endblock %}`,
 		expect("Hello, World!"),
 	),
+	newExecTest(
+		"Multi-level parent",
+		`{% extends layout %}{% block message %}{{ parent() }}, World{% endblock %}`,
+		expect("Hello, Universe, World"),
+		withContext(map[string]Value{"layout": `{% extends '{% block message %}Hello{% endblock %}' %}{% block message %}{{ parent() }}, Universe{% endblock %}`}),
+	),
+	newExecTest(
+		"Arrow function",
+		`{{ 5|call(x => x * factor) }} {{ 'a'|call((x, y) => x ~ (y ?? '-')) }} {{ 1|call(() => 'none') }} {{ 1|call(factor => factor) }}{{ factor }}`,
+		expect("10 a- none 12"),
+		withContext(map[string]Value{"factor": 2}),
+	),
+	newExecTest(
+		"Arrow function captures scope",
+		`{% set n = 3 %}{% set add = x => x + n %}{% set n = 10 %}{{ 1|call(add) }} {% for i in [1, 2] %}{{ 0|call(x => x + i) }}{% endfor %}`,
+		expect("4 12"),
+	),
+	newExecTest(
+		"Call non-arrow",
+		`{{ 1|call('upper') }}`,
+		expect("stick: unable to call string, expected an arrow function"),
+	),
+	newExecTest(
+		"Null coalesce",
+		`{{ missing ?? 'a' }} {{ nothing ?? 'b' }} {{ name ?? 'c' }} {{ data.missing ?? missing ?? 'd' }} {{ name ?? undeclared() }}`,
+		expect("a b Tyler d Tyler"),
+		withContext(map[string]Value{"nothing": nil, "name": "Tyler", "data": map[string]Value{}}),
+	),
 	newExecTest(
 		"Set statement",
 		`{% set val = 'a value' %}{{ val }}`,
@@ -153,6 +186,49 @@ var tests = []execTest{
 		`{% filter upper %}hello, world!{% endfilter %}`,
 		expect("HELLO, WORLD!"),
 	),
+	newExecTest(
+		"Apply statement",
+		`{% apply pad(6, 'left', '-')|upper %}{{ name }}{% endapply %}|{% apply upper|pad(char='.', length=5) %}a{% endapply %}`,
+		expect("--TEST|A...."),
+		withContext(map[string]Value{"name": "test"}),
+	),
+	newExecTest(
+		"Apply statement undefined filter",
+		`{% apply upper|missing %}text{% endapply %}`,
+		expectErrorContains(`Undeclared filter "missing" on line 1, column 15`),
+	),
+	newExecTest(
+		"Spaceless statement",
+		"{% spaceless %}\n<ul>\n  <li> {{ name }} </li>\n</ul>\n{% endspaceless %}",
+		expect("<ul><li> test </li></ul>"),
+		withContext(map[string]Value{"name": "test"}),
+	),
+	newExecTest(
+		"Range operator NaN",
+		`{% set x = 0..(0/0) %}`,
+		expectErrorContains("range bounds and step must be finite numbers on line 1, column 11"),
+	),
+	newExecTest(
+		"With statement",
+		`{% set a = 1 %}{% with {b: 2} %}{{ a }}{{ b }}{% set a = 3 %}{% set c = 4 %}{{ a }}{% endwith %}{{ a }}{{ b }}{{ c }}`,
+		expect("1231"),
+	),
+	newExecTest(
+		"With statement only",
+		`{% set a = 1 %}{% with {b: 2} only %}{{ a }}{{ b }}{% endwith %}`,
+		expect("2"),
+	),
+	newExecTest(
+		"With statement not a hash",
+		`{% with 'foo' %}{% endwith %}`,
+		expectErrorContains(`variables passed to the "with" tag must be a hash, got string on line 1, column 9`),
+	),
+	newExecTest(
+		"Autoescape statement",
+		`{% autoescape 'js' %}{{ name|upper }}{% endautoescape %}`,
+		expect("<B>"),
+		withContext(map[string]Value{"name": "<b>"}),
+	),
 	newExecTest(
 		"Import statement",
 		`{% import 'macros.twig' as mac %}{{ mac.test("hi") }}`,
@@ -163,6 +239,41 @@ var tests = []execTest{
 		`{% from 'macros.twig' import test, def as other %}{{ other("", "HI!") }}`,
 		expect("HI!"),
 	),
+	newExecTest(
+		"Named macro arguments",
+		`{% import 'macros.twig' as mac %}{% from 'macros.twig' import def %}{{ mac.def(default="A", val="") }} {{ def("", default="B") }} {{ mac.test(arg="C") }} {{ def(default="D") }}`,
+		expect("A B test: C D"),
+	),
+	newExecTest(
+		"Named arguments",
+		`{{ multiply(b=3, a=2) }} {{ multiply(4, b=5) }} {{ 'a'|pad(side='left', length=3) }} {{ 'b'|pad(3, char='.') }}`,
+		expect("6 20   a b.."),
+	),
+	newExecTest(
+		"Unknown named argument",
+		`{{ multiply(a=1, c=2) }}`,
+		expectErrorContains(`unknown argument "c" for function "multiply" on line 1, column 17`),
+	),
+	newExecTest(
+		"Named argument defined twice",
+		`{% import 'macros.twig' as mac %}{{ mac.test("a", arg="b") }}`,
+		expectErrorContains(`argument "arg" for macro "test" is defined twice`),
+	),
+	newExecTest(
+		"Named argument without parameters",
+		`{{ 'a'|upper(case="lower") }}`,
+		expectErrorContains(`unknown argument "case" for filter "upper"`),
+	),
+	newExecTest(
+		"Macro default values",
+		`{% macro input(name, type='text', size=width * 2) %}{{ name }} {{ type }} {{ size }}{% endmacro %}{% set width = 10 %}{{ _self.input('a') }}, {{ _self.input('b', null, size=5) }}, {% set width = 1 %}{{ _self.input('c', type='email') }}`,
+		expect("a text 20, b  5, c email 2"),
+	),
+	newExecTest(
+		"Macro varargs",
+		`{% macro list(sep) %}{% for v in varargs %}{{ v }}{% if not loop.last %}{{ sep }}{% endif %}{% endfor %}{% endmacro %}{{ _self.list(', ', 1, 2, 3) }}; {{ _self.list(', ') }}`,
+		expect("1, 2, 3; "),
+	),
 	newExecTest(
 		"Ternary if",
 		`{{ false ? (true ? "Hello" : "World") : "Words" }}`,
@@ -211,6 +322,27 @@ var tests = []execTest{
 		`Template: {{ _self.templateName }}`,
 		expect("Template: Template: {{ _self.templateName }}"),
 	),
+	newExecTest(
+		"Whitespace control",
+		"<ul>\n  {%- for i in 1..2 %}\n    <li>{{- i -}}</li>\n  {%- endfor %}\n</ul>",
+		expect("<ul>\n    <li>1</li>\n    <li>2</li>\n</ul>"),
+	),
+	newExecTest(
+		"Whitespace control with if else",
+		"{% if active -%}\n\tYes\n{%- else -%}\n\tNo\n{%- endif %}",
+		expect("No"),
+		withContext(map[string]Value{"active": false}),
+	),
+	newExecTest(
+		"Line whitespace control",
+		"<div>\n    {{~ 'text' ~}}   \n</div>",
+		expect("<div>\ntext\n</div>"),
+	),
+	newExecTest(
+		"Whitespace control comment",
+		"Hello   {#- comment -#}\n, World",
+		expect("Hello, World"),
+	),
 	newExecTest(
 		"Unsupported binary operator",
 		`{{ 1 + 2 }}`,
@@ -402,7 +534,7 @@ func (v *testVisitor) Leave(n parse.Node) {
 
 func evaluateTest(t *testing.T, env *Env, test execTest) {
 	w := &bytes.Buffer{}
-	err := execute(test.tpl, w, test.ctx, env)
+	err := execute(context.Background(), test.tpl, w, test.ctx, env)
 
 	out := w.String()
 	if err := test.checkResult(out, err); err != nil {
@@ -441,6 +573,36 @@ func TestExec(t *testing.T) {
 		}
 		return val
 	}
+	env.FunctionParams["multiply"] = []string{"a", "b"}
+	env.Filters["pad"] = func(ctx Context, val Value, args ...Value) Value {
+		str := CoerceString(val)
+		if len(args) == 0 {
+			return str
+		}
+		char := " "
+		if len(args) > 2 && args[2] != nil {
+			char = CoerceString(args[2])
+		}
+		n := int(CoerceNumber(args[0])) - len(str)
+		if n <= 0 {
+			return str
+		}
+		if len(args) > 1 && CoerceString(args[1]) == "left" {
+			return strings.Repeat(char, n) + str
+		}
+		return str + strings.Repeat(char, n)
+	}
+	env.FilterParams["pad"] = []string{"length", "side", "char"}
+	env.Filters["call"] = func(ctx Context, val Value, args ...Value) Value {
+		if len(args) == 0 {
+			return nil
+		}
+		res, err := ctx.Call(args[0], val)
+		if err != nil {
+			return err.Error()
+		}
+		return res
+	}
 	tv := &testVisitor{}
 	env.Visitors = append(env.Visitors, tv)
 	for _, test := range tests {
@@ -457,3 +619,189 @@ func (p *fakePerson) Name(prefix string) string {
 	p.name = prefix + p.name
 	return p.name
 }
+
+func TestConcurrentExecute(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"base.twig":   `{% block title %}Base{% endblock %}: {% block body %}{% endblock %}`,
+		"blocks.twig": `{% block body %}Used{% endblock %}`,
+		"macros.twig": `{% macro greet(name) %}Hello, {{ name }}{% endmacro %}`,
+		"embed.twig":  `[{% block inner %}{% endblock %}]`,
+		"child.twig": `{% extends 'base.twig' %}{% use 'blocks.twig' with body as used_body %}` +
+			`{% block title %}{{ parent() }} {{ title }}{% endblock %}` +
+			`{% block body %}{% import 'macros.twig' as m %}{% set greeting = m.greet(name) %}{{ greeting }} {{ block('used_body') }} ` +
+			`{% embed 'embed.twig' %}{% block inner %}{{ name }}{% endblock %}{% endembed %}` +
+			`{% for i in 1..3 %}{{ i }}{% endfor %}{% endblock %}`,
+	}})
+	env.Cache = NewMemoryTemplateCache(0)
+	tree, err := env.Parse("child.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := tree.Root().String()
+	blocks := len(tree.Blocks())
+	shared := map[string]Value{"title": "Page"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("User%d", i)
+			ctx := map[string]Value{"name": name}
+			for k, v := range shared {
+				ctx[k] = v
+			}
+			for j := 0; j < 10; j++ {
+				buf := &bytes.Buffer{}
+				if err := env.Execute("child.twig", buf, ctx); err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+				expected := "Base Page: Hello, " + name + " Used [" + name + "]123"
+				if res := buf.String(); res != expected {
+					t.Errorf("expected %#v, got %#v", expected, res)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if after := tree.Root().String(); after != before {
+		t.Errorf("expected tree to be unmodified, got %s", after)
+	}
+	if l := len(tree.Blocks()); l != blocks {
+		t.Errorf("expected %d blocks, got %d", blocks, l)
+	}
+	if _, ok := shared["greeting"]; ok {
+		t.Errorf("expected context to be unmodified")
+	}
+}
+
+// cause returns the error underlying an ExecutionError.
+func cause(err error) error {
+	if e, ok := err.(*ExecutionError); ok {
+		return e.Err
+	}
+	return err
+}
+
+func TestFunctionFail(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"range.twig":  "{{ range(1, 2) }}",
+		"func.twig":   "\n{{ fail() }}",
+		"filter.twig": "{{ 'a'|fail }}",
+	}})
+	env.Functions["range"] = func(ctx Context, args ...Value) Value {
+		return "mine"
+	}
+	env.Functions["fail"] = func(ctx Context, args ...Value) Value {
+		ctx.Fail(&LimitError{Limit: "widgets", Max: 1})
+		return nil
+	}
+	env.Filters["fail"] = func(ctx Context, val Value, args ...Value) Value {
+		ctx.Fail(errors.New("filter failed"))
+		return val
+	}
+	buf := &bytes.Buffer{}
+	if err := env.Execute("range.twig", buf, nil); err != nil || buf.String() != "mine" {
+		t.Errorf("unexpected result %#v, %v", buf.String(), err)
+	}
+	err := env.Execute("func.twig", &bytes.Buffer{}, nil)
+	if _, ok := cause(err).(*LimitError); !ok {
+		t.Errorf("expected LimitError, got %#v", err)
+	} else if expected := "execute: exceeded limit of 1 widgets on line 2, column 3 in func.twig"; cause(err).Error() != expected {
+		t.Errorf("expected error %#v, got %#v", expected, cause(err).Error())
+	}
+	err = env.Execute("filter.twig", &bytes.Buffer{}, nil)
+	if err == nil || cause(err).Error() != "filter failed" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+type contextKey struct{}
+
+func TestExecuteContext(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"loop.twig":     "{% for i in 1..10 %}{{ i }}{{ stop(i) }}{% endfor %}",
+		"include.twig":  "Hello{% include 'loop.twig' %}",
+		"macro.twig":    "{% macro m() %}!{% endmacro %}{{ _self.m() }}",
+		"function.twig": "Hello{{ include('loop.twig') }}",
+	}})
+	env.Functions["include"] = func(ctx Context, args ...Value) Value {
+		out, err := ctx.Include(args[0], nil)
+		if err != nil {
+			ctx.Fail(err)
+		}
+		return out
+	}
+	env.Functions["stop"] = func(ctx Context, args ...Value) Value {
+		if CoerceNumber(args[0]) == 3 {
+			ctx.Context().Value(contextKey{}).(context.CancelFunc)()
+		}
+		return nil
+	}
+
+	c, cancel := context.WithCancel(context.Background())
+	c = context.WithValue(c, contextKey{}, cancel)
+	buf := &bytes.Buffer{}
+	err := env.ExecuteContext(c, "loop.twig", buf, nil)
+	if buf.String() != "123" {
+		t.Errorf("expected loop to stop after 3 iterations, got %#v", buf.String())
+	}
+	cerr, ok := cause(err).(*CancelError)
+	if !ok {
+		t.Fatalf("expected CancelError, got %#v", err)
+	}
+	if cerr.Err != context.Canceled || cerr.Name != "loop.twig" || cerr.Pos.Line != 1 {
+		t.Errorf("unexpected error %s", cerr)
+	}
+
+	for _, name := range []string{"include.twig", "macro.twig", "function.twig"} {
+		buf := &bytes.Buffer{}
+		err := env.ExecuteContext(c, name, buf, nil)
+		if _, ok := cause(err).(*CancelError); !ok {
+			t.Errorf("%s: expected CancelError, got %#v", name, err)
+		}
+		if buf.String() == "!" {
+			t.Errorf("%s: expected execution to stop, got %#v", name, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := env.ExecuteContext(context.Background(), "macro.twig", buf, nil); err != nil || buf.String() != "!" {
+		t.Errorf("unexpected result %#v, %v", buf.String(), err)
+	}
+}
+
+func TestExecutionError(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"page.twig":   "{% extends 'base.twig' %}{% block body %}\n  {% include 'part.twig' %}{% endblock %}",
+		"base.twig":   "{% block body %}{% endblock %}",
+		"part.twig":   "{% import 'macros.twig' as m %}\n{{ m.fail() }}",
+		"macros.twig": "{% macro fail() %}\n\n    {{ missing() }}{% endmacro %}",
+	}})
+	err := env.Execute("page.twig", &bytes.Buffer{}, nil)
+	eerr, ok := err.(*ExecutionError)
+	if !ok {
+		t.Fatalf("expected ExecutionError, got %#v", err)
+	}
+	if eerr.Name != "macros.twig" || eerr.Pos != (parse.Pos{Line: 3, Offset: 7}) {
+		t.Errorf("unexpected location %s %s", eerr.Name, eerr.Pos)
+	}
+	expected := []Frame{
+		{"extends", "page.twig", parse.Pos{Line: 1, Offset: 3}},
+		{"include", "page.twig", parse.Pos{Line: 2, Offset: 5}},
+		{"macro", "part.twig", parse.Pos{Line: 2, Offset: 4}},
+	}
+	if fmt.Sprint(eerr.Stack) != fmt.Sprint(expected) {
+		t.Errorf("expected stack %v, got %v", expected, eerr.Stack)
+	}
+	expectedMsg := `execute: Undeclared function "missing" on line 3, column 7 in macros.twig, ` +
+		`from macro on line 2, column 4 in part.twig, ` +
+		`from include on line 2, column 5 in page.twig, ` +
+		`from extends on line 1, column 3 in page.twig`
+	if err.Error() != expectedMsg {
+		t.Errorf("expected message %#v, got %#v", expectedMsg, err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+package stick
+
+import (
+	"errors"
+	"io"
+	"math"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// Limits restricts the resources used when executing a template. A zero value
+// for any limit means the resource is not limited.
+//
+// Exceeding a limit stops execution and returns an error wrapping a *LimitError.
+type Limits struct {
+	MaxDepth      int // Maximum depth of nested includes, embeds, parent templates, macro calls and block functions.
+	MaxIterations int // Maximum total number of for loop iterations.
+	MaxRangeSize  int // Maximum number of elements created by the range operator or function.
+	MaxOutput     int // Maximum total number of bytes written, including output captured by set, apply and macros.
+}
+
+// usage tracks the resources used by an execution. It is shared by the State
+// of each included or embedded template.
+type usage struct {
+	depth      int
+	iterations int
+	output     int
+}
+
+func (s *State) limitError(limit string, max int, pos parse.Pos) error {
+	return &LimitError{limit, max, s.name, pos}
+}
+
+// enter increases the execution depth, returning a LimitError if it exceeds
+// the Env's limit. A successful call to enter must be followed by a call to leave.
+func (s *State) enter(pos parse.Pos) error {
+	if max := s.env.Limits.MaxDepth; max > 0 && s.usage.depth >= max {
+		return s.limitError("include and macro depth", max, pos)
+	}
+	s.usage.depth++
+	return nil
+}
+
+// leave decreases the execution depth.
+func (s *State) leave() {
+	s.usage.depth--
+}
+
+// iterate counts a loop iteration, returning a LimitError if the total number
+// of iterations exceeds the Env's limit.
+func (s *State) iterate(pos parse.Pos) error {
+	s.usage.iterations++
+	if max := s.env.Limits.MaxIterations; max > 0 && s.usage.iterations > max {
+		return s.limitError("loop iterations", max, pos)
+	}
+	return nil
+}
+
+// maxRangeSize is the size of the largest range created when MaxRangeSize is
+// not set, so that the size of a range always fits in an int.
+const maxRangeSize = math.MaxInt32
+
+// RangeSize returns the number of elements in a range from low to high,
+// inclusive, in increments of step. It returns a *LimitError if the range is
+// larger than MaxRangeSize, and an error if a bound or the step is infinite
+// or NaN.
+func (l Limits) RangeSize(low, high, step float64) (int, error) {
+	for _, v := range []float64{low, high, step} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, errors.New("range bounds and step must be finite numbers")
+		}
+	}
+	n := math.Floor(math.Abs(high-low)/step) + 1
+	max := l.MaxRangeSize
+	if max <= 0 {
+		max = maxRangeSize
+	}
+	// Written so that a NaN size fails the check.
+	if !(n <= float64(max)) {
+		return 0, &LimitError{Limit: "range size", Max: max}
+	}
+	return int(n), nil
+}
+
+// write writes str to the output, returning a LimitError if the total number
+// of bytes written, whether to the output or a buffer capturing it, exceeds
+// the Env's limit.
+func (s *State) write(pos parse.Pos, str string) error {
+	if max := s.env.Limits.MaxOutput; max > 0 {
+		if s.usage.output+len(str) > max {
+			return s.limitError("output bytes", max, pos)
+		}
+		s.usage.output += len(str)
+	}
+	_, err := io.WriteString(s.out, str)
+	return err
+}
@@ -0,0 +1,65 @@
+package stick
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"include.twig":  `{% include 'include.twig' %}`,
+		"extends.twig":  `{% extends 'extends.twig' %}`,
+		"macro.twig":    `{% macro m(n) %}{{ _self.m(n + 1) }}{% endmacro %}{{ _self.m(1) }}`,
+		"block.twig":    `{% block a %}{{ block('a') }}{% endblock %}`,
+		"loop.twig":     `{% for i in 1..5 %}{% for j in 1..5 %}{% endfor %}{% endfor %}`,
+		"range.twig":    `{% for i in 1..1000000000 %}{% endfor %}`,
+		"rangenan.twig": `{% set y = 0..(0/0) %}`,
+		"output.twig":   `{% for i in 1..10 %}0123456789{% endfor %}`,
+		"capture.twig":  `{% set x %}{% for i in 1..10 %}0123456789{% endfor %}{% endset %}`,
+		"ok.twig":       `{% for i in 1..3 %}{{ i }}{% endfor %}{% include 'small.twig' %}`,
+		"small.twig":    `!`,
+	}})
+	env.Limits = Limits{
+		MaxDepth:      10,
+		MaxIterations: 20,
+		MaxRangeSize:  1000,
+		MaxOutput:     50,
+	}
+
+	tests := []struct {
+		tpl string
+		err string
+	}{
+		{"include.twig", "execute: exceeded limit of 10 include and macro depth on line 1, column 3 in include.twig"},
+		{"extends.twig", "execute: exceeded limit of 10 include and macro depth on line 1, column 3 in extends.twig"},
+		{"macro.twig", "execute: exceeded limit of 10 include and macro depth on line 1, column 24 in macro.twig"},
+		{"block.twig", "execute: exceeded limit of 10 include and macro depth on line 1, column 16 in block.twig"},
+		{"loop.twig", "execute: exceeded limit of 20 loop iterations on line 1, column 22 in loop.twig"},
+		{"range.twig", "execute: exceeded limit of 1000 range size on line 1, column 12 in range.twig"},
+		{"output.twig", "execute: exceeded limit of 50 output bytes on line 1, column 20 in output.twig"},
+		{"capture.twig", "execute: exceeded limit of 50 output bytes on line 1, column 31 in capture.twig"},
+	}
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		err := cause(env.Execute(test.tpl, buf, nil))
+		if _, ok := err.(*LimitError); !ok {
+			t.Errorf("%s: expected LimitError, got %#v", test.tpl, err)
+		} else if err.Error() != test.err {
+			t.Errorf("%s: expected error %#v, got %#v", test.tpl, test.err, err.Error())
+		}
+	}
+
+	if err := env.Execute("rangenan.twig", &bytes.Buffer{}, nil); err == nil {
+		t.Errorf("rangenan.twig: expected error for a NaN range")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := env.Execute("ok.twig", buf, nil); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if buf.String() != "123!" {
+		t.Errorf("unexpected output %#v", buf.String())
+	}
+	if buf := bytes.NewBuffer(nil); env.Execute("output.twig", buf, nil) == nil || buf.Len() != 50 {
+		t.Errorf("expected output to stop at 50 bytes, got %d", buf.Len())
+	}
+}
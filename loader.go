@@ -2,9 +2,11 @@ package stick
 
 import (
 	"bytes"
+	"hash/fnv"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 // Loader defines a type that can load Stick templates using the given name.
@@ -48,6 +50,17 @@ func (l *MemoryLoader) Load(name string) (Template, error) {
 	return &stringTemplate{name, v}, nil
 }
 
+// CacheKey returns a hash of the template contents.
+func (l *MemoryLoader) CacheKey(name string) (string, error) {
+	v, ok := l.Templates[name]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	h := fnv.New64a()
+	io.WriteString(h, v)
+	return strconv.FormatUint(h.Sum64(), 16), nil
+}
+
 type fileTemplate struct {
 	name   string
 	reader io.Reader
@@ -81,3 +94,12 @@ func (l *FilesystemLoader) Load(name string) (Template, error) {
 	}
 	return &fileTemplate{name, f}, nil
 }
+
+// CacheKey returns a key based on the modification time and size of the given file.
+func (l *FilesystemLoader) CacheKey(name string) (string, error) {
+	fi, err := os.Stat(filepath.Join(l.rootDir, name))
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(fi.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(fi.Size(), 16), nil
+}
@@ -1,8 +1,10 @@
 package stick
 
 import (
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -50,3 +52,40 @@ func TestMemoryLoader(t *testing.T) {
 		t.Fatalf("expected 'some text' got '%s'", string(s))
 	}
 }
+
+// closeRecorder is a template reader that records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+type closingTemplate struct {
+	name   string
+	reader *closeRecorder
+}
+
+func (t *closingTemplate) Name() string        { return t.name }
+func (t *closingTemplate) Contents() io.Reader { return t.reader }
+
+func TestTemplateReaderClosed(t *testing.T) {
+	r := &closeRecorder{Reader: strings.NewReader("Hello")}
+	env := New(nil)
+	if _, err := env.parse(loaderFunc(func(name string) (Template, error) {
+		return &closingTemplate{name, r}, nil
+	}), "hello.twig"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !r.closed {
+		t.Errorf("expected template reader to be closed after parsing")
+	}
+}
+
+// loaderFunc adapts a function to the Loader interface.
+type loaderFunc func(name string) (Template, error)
+
+func (f loaderFunc) Load(name string) (Template, error) { return f(name) }
@@ -133,3 +133,45 @@ func (e *MultipleExtendsError) Error() string {
 func newMultipleExtendsError(start Pos) error {
 	return &MultipleExtendsError{newBaseError(start)}
 }
+
+// InvalidSandboxError describes a sandbox tag containing something other than include tags.
+type InvalidSandboxError struct {
+	baseError
+}
+
+func (e *InvalidSandboxError) Error() string {
+	return e.sprintf(`only "include" tags are allowed within a "sandbox" tag`)
+}
+
+// newInvalidSandboxError returns a new InvalidSandboxError
+func newInvalidSandboxError(start Pos) error {
+	return &InvalidSandboxError{newBaseError(start)}
+}
+
+// PositionalArgError describes a positional argument following a named argument.
+type PositionalArgError struct {
+	baseError
+}
+
+func (e *PositionalArgError) Error() string {
+	return e.sprintf(`positional arguments cannot be used after named arguments`)
+}
+
+// newPositionalArgError returns a new PositionalArgError
+func newPositionalArgError(start Pos) error {
+	return &PositionalArgError{newBaseError(start)}
+}
+
+// InvalidEscapeStrategyError describes an autoescape tag with a strategy that is not a string or false.
+type InvalidEscapeStrategyError struct {
+	baseError
+}
+
+func (e *InvalidEscapeStrategyError) Error() string {
+	return e.sprintf(`an escaping strategy must be a string or false`)
+}
+
+// newInvalidEscapeStrategyError returns a new InvalidEscapeStrategyError
+func newInvalidEscapeStrategyError(start Pos) error {
+	return &InvalidEscapeStrategyError{newBaseError(start)}
+}
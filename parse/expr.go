@@ -140,6 +140,29 @@ func (exp *FuncExpr) String() string {
 	return fmt.Sprintf("FuncExpr(%s, %s)", exp.Name, exp.Args)
 }
 
+// NamedArgExpr represents a named argument passed to a function, filter or
+// macro, such as "pretty=true".
+type NamedArgExpr struct {
+	Pos
+	Name  string // The name of the argument.
+	Value Expr   // The value of the argument.
+}
+
+// NewNamedArgExpr returns a NamedArgExpr.
+func NewNamedArgExpr(name string, val Expr, pos Pos) *NamedArgExpr {
+	return &NamedArgExpr{pos, name, val}
+}
+
+// All returns all the child Nodes in a NamedArgExpr.
+func (exp *NamedArgExpr) All() []Node {
+	return []Node{exp.Value}
+}
+
+// String returns a string representation of a NamedArgExpr.
+func (exp *NamedArgExpr) String() string {
+	return fmt.Sprintf("NamedArgExpr(%s = %s)", exp.Name, exp.Value)
+}
+
 // FilterExpr represents a filter application.
 type FilterExpr struct {
 	*FuncExpr
@@ -357,3 +380,25 @@ func (exp *ArrayExpr) All() []Node {
 func (exp *ArrayExpr) String() string {
 	return fmt.Sprintf("ArrayExpr%v", exp.Elements)
 }
+
+// ArrowExpr represents an arrow function, such as "(a, b) => a + b".
+type ArrowExpr struct {
+	Pos
+	Args []string // Names of the arguments.
+	Body Expr     // Expression evaluated when the function is called.
+}
+
+// NewArrowExpr returns an ArrowExpr.
+func NewArrowExpr(args []string, body Expr, pos Pos) *ArrowExpr {
+	return &ArrowExpr{pos, args, body}
+}
+
+// All returns all the child Nodes in an ArrowExpr.
+func (exp *ArrowExpr) All() []Node {
+	return []Node{exp.Body}
+}
+
+// String returns a string representation of an ArrowExpr.
+func (exp *ArrowExpr) String() string {
+	return fmt.Sprintf("ArrowExpr(%v => %s)", exp.Args, exp.Body)
+}
@@ -80,7 +80,14 @@ const (
 	delimOpenInterpolate  = "#{"
 	delimCloseInterpolate = "}"
 	delimTrimWhitespace   = "-"
+	delimTrimLineSpace    = "~"
 	delimHashKeyValue     = ":"
+	delimArrow            = "=>"
+)
+
+const (
+	whitespaceChars     = " \t\n\r\x00\x0B" // Removed by the "-" whitespace modifier.
+	lineWhitespaceChars = " \t\x00\x0B"     // Removed by the "~" whitespace modifier.
 )
 
 type Token struct {
@@ -181,9 +188,31 @@ func (l *lexer) emit(t TokenType) {
 	if l.pos <= len(l.input) {
 		val = l.input[l.start:l.pos]
 	}
+	l.emitValue(t, val)
+}
 
+// emitValue creates a Token with the given value, positioned at the last
+// emission. The lexer then continues from the current cursor position.
+func (l *lexer) emitValue(t TokenType, val string) {
 	tok := Token{val, t, Pos{l.line, l.offset}}
 
+	l.ignore()
+
+	l.tokens <- tok
+	if tok.tokenType == TokenEOF {
+		close(l.tokens)
+		l.mode = modeClosed
+	}
+}
+
+// ignore skips over the input from the last emission until the current
+// cursor position, keeping track of line and offset.
+func (l *lexer) ignore() {
+	val := ""
+	if l.pos <= len(l.input) {
+		val = l.input[l.start:l.pos]
+	}
+
 	if c := strings.Count(val, "\n"); c > 0 {
 		l.line += c
 		lpos := strings.LastIndex(val, "\n")
@@ -192,12 +221,61 @@ func (l *lexer) emit(t TokenType) {
 		l.offset += len(val)
 	}
 
-	l.tokens <- tok
 	l.start = l.pos
-	if tok.tokenType == TokenEOF {
-		close(l.tokens)
-		l.mode = modeClosed
+}
+
+// emitText emits any pending text. If the opening delimiter at the cursor
+// has a whitespace modifier, trailing whitespace is removed from the text.
+// A Token is not emitted if no text remains.
+func (l *lexer) emitText() {
+	if l.pos <= l.start {
+		return
+	}
+	val := l.input[l.start:l.pos]
+	if chars := l.trimChars(l.pos + len(delimOpenTag)); chars != "" {
+		val = strings.TrimRight(val, chars)
 	}
+	if val == "" {
+		l.ignore()
+		return
+	}
+	l.emitValue(TokenText, val)
+}
+
+// trimChars returns the characters to be trimmed if a whitespace modifier
+// exists at the given position in the input, or an empty string.
+func (l *lexer) trimChars(pos int) string {
+	if pos >= len(l.input) {
+		return ""
+	}
+	switch l.input[pos : pos+1] {
+	case delimTrimWhitespace:
+		return whitespaceChars
+	case delimTrimLineSpace:
+		return lineWhitespaceChars
+	}
+	return ""
+}
+
+// acceptTrim consumes a whitespace modifier at the cursor, if one exists,
+// returning the characters it trims.
+func (l *lexer) acceptTrim() string {
+	chars := l.trimChars(l.pos)
+	if chars != "" {
+		l.pos++
+	}
+	return chars
+}
+
+// skipTrimmed skips any characters in chars following the cursor.
+func (l *lexer) skipTrimmed(chars string) {
+	if chars == "" {
+		return
+	}
+	for l.pos < len(l.input) && strings.ContainsAny(l.input[l.pos:l.pos+1], chars) {
+		l.pos++
+	}
+	l.ignore()
 }
 
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
@@ -211,21 +289,15 @@ func lexData(l *lexer) stateFn {
 	for {
 		switch {
 		case strings.HasPrefix(l.input[l.pos:], delimOpenComment):
-			if l.pos > l.start {
-				l.emit(TokenText)
-			}
+			l.emitText()
 			return lexCommentOpen
 
 		case strings.HasPrefix(l.input[l.pos:], delimOpenTag):
-			if l.pos > l.start {
-				l.emit(TokenText)
-			}
+			l.emitText()
 			return lexTagOpen
 
 		case strings.HasPrefix(l.input[l.pos:], delimOpenPrint):
-			if l.pos > l.start {
-				l.emit(TokenText)
-			}
+			l.emitText()
 			return lexPrintOpen
 		}
 
@@ -254,19 +326,26 @@ func lexExpression(l *lexer) stateFn {
 		return lexData
 
 	case strings.HasPrefix(l.input[l.pos:], delimCloseTag),
-		strings.HasPrefix(l.input[l.pos:], delimTrimWhitespace+delimCloseTag):
+		strings.HasPrefix(l.input[l.pos:], delimTrimWhitespace+delimCloseTag),
+		strings.HasPrefix(l.input[l.pos:], delimTrimLineSpace+delimCloseTag):
 		if l.pos > l.start {
 			return l.errorf("pos > start, previous Token not emitted?")
 		}
 		return lexTagClose
 
 	case strings.HasPrefix(l.input[l.pos:], delimClosePrint),
-		strings.HasPrefix(l.input[l.pos:], delimTrimWhitespace+delimClosePrint):
+		strings.HasPrefix(l.input[l.pos:], delimTrimWhitespace+delimClosePrint),
+		strings.HasPrefix(l.input[l.pos:], delimTrimLineSpace+delimClosePrint):
 		if l.pos > l.start {
 			return l.errorf("pos > start, previous Token not emitted?")
 		}
 		return lexPrintClose
 
+	case strings.HasPrefix(l.input[l.pos:], delimArrow):
+		l.pos += len(delimArrow)
+		l.emit(TokenPunctuation)
+		return lexExpression
+
 	case isPunctuation(str):
 		return lexPunctuation
 
@@ -314,9 +393,10 @@ func (l *lexer) tryLexOperator() bool {
 		if (l.pos+lenOp+1) <= len(l.input) && l.input[l.pos+lenOp:l.pos+lenOp+1] != " " {
 			return false
 		}
-	} else if op == delimTrimWhitespace {
-		switch l.input[l.pos+1 : l.pos+3] {
-		case delimClosePrint, delimCloseTag:
+	} else if op == delimTrimWhitespace || op == delimTrimLineSpace {
+		// Ensure this is not a whitespace modifier on a closing delimiter, like "-}}" or "~%}".
+		rest := l.input[l.pos+1:]
+		if strings.HasPrefix(rest, delimClosePrint) || strings.HasPrefix(rest, delimCloseTag) {
 			return false
 		}
 	}
@@ -482,16 +562,14 @@ func lexName(l *lexer) stateFn {
 
 func lexCommentOpen(l *lexer) stateFn {
 	l.pos += len(delimOpenComment)
-	if l.peek() == delimTrimWhitespace {
-		l.pos++
-	}
+	l.acceptTrim()
 	l.emit(TokenCommentOpen)
 	til := strings.Index(l.input[l.pos:], delimCloseComment)
 	if til < 0 {
 		til = len(l.input[l.start:])
 	}
 	l.pos += til
-	if string(l.input[l.pos-1]) == delimTrimWhitespace {
+	if til > 0 && l.trimChars(l.pos-1) != "" {
 		l.backup()
 		l.emit(TokenText)
 		l.next()
@@ -501,17 +579,17 @@ func lexCommentOpen(l *lexer) stateFn {
 	if !strings.HasPrefix(l.input[l.pos:], delimCloseComment) {
 		return l.errorf("expected comment close")
 	}
+	chars := l.trimChars(l.pos - 1)
 	l.pos += len(delimCloseComment)
 	l.emit(TokenCommentClose)
+	l.skipTrimmed(chars)
 
 	return lexData
 }
 
 func lexTagOpen(l *lexer) stateFn {
 	l.pos += len(delimOpenTag)
-	if l.peek() == delimTrimWhitespace {
-		l.pos++
-	}
+	l.acceptTrim()
 	l.emit(TokenTagOpen)
 
 	return lexExpression
@@ -521,20 +599,17 @@ func lexTagClose(l *lexer) stateFn {
 	if l.parens > 0 {
 		return l.errorf("unclosed parenthesis")
 	}
-	if l.peek() == delimTrimWhitespace {
-		l.pos++
-	}
+	chars := l.acceptTrim()
 	l.pos += len(delimCloseTag)
 	l.emit(TokenTagClose)
+	l.skipTrimmed(chars)
 
 	return lexData
 }
 
 func lexPrintOpen(l *lexer) stateFn {
 	l.pos += len(delimOpenPrint)
-	if l.peek() == delimTrimWhitespace {
-		l.pos++
-	}
+	l.acceptTrim()
 	l.emit(TokenPrintOpen)
 
 	return lexExpression
@@ -544,11 +619,10 @@ func lexPrintClose(l *lexer) stateFn {
 	if l.parens > 0 {
 		return l.errorf("unclosed parenthesis")
 	}
-	if l.peek() == delimTrimWhitespace {
-		l.pos++
-	}
+	chars := l.acceptTrim()
 	l.pos += len(delimClosePrint)
 	l.emit(TokenPrintClose)
+	l.skipTrimmed(chars)
 
 	return lexData
 }
@@ -31,6 +31,10 @@ var (
 	tPrintClose       = mkTok(TokenPrintClose, delimClosePrint)
 	tPrintTrimOpen    = mkTok(TokenPrintOpen, delimOpenPrint+delimTrimWhitespace)
 	tPrintTrimClose   = mkTok(TokenPrintClose, delimTrimWhitespace+delimClosePrint)
+	tTagLineOpen      = mkTok(TokenTagOpen, delimOpenTag+delimTrimLineSpace)
+	tTagLineClose     = mkTok(TokenTagClose, delimTrimLineSpace+delimCloseTag)
+	tPrintLineOpen    = mkTok(TokenPrintOpen, delimOpenPrint+delimTrimLineSpace)
+	tPrintLineClose   = mkTok(TokenPrintClose, delimTrimLineSpace+delimClosePrint)
 	tDblStringOpen    = mkTok(TokenStringOpen, "\"")
 	tDblStringClose   = mkTok(TokenStringClose, "\"")
 	tStringOpen       = mkTok(TokenStringOpen, "'")
@@ -118,6 +122,41 @@ var lexTests = []lexTest{
 		tEOF,
 	}},
 
+	{"null coalesce", "{{ a ?? b }}", []Token{
+		tPrintOpen,
+		tSpace,
+		mkTok(TokenName, "a"),
+		tSpace,
+		mkTok(TokenOperator, "??"),
+		tSpace,
+		mkTok(TokenName, "b"),
+		tSpace,
+		tPrintClose,
+		tEOF,
+	}},
+
+	{"arrow function", "{{ (a, b) => a >= b }}", []Token{
+		tPrintOpen,
+		tSpace,
+		mkTok(TokenParensOpen, "("),
+		mkTok(TokenName, "a"),
+		mkTok(TokenPunctuation, ","),
+		tSpace,
+		mkTok(TokenName, "b"),
+		mkTok(TokenParensClose, ")"),
+		tSpace,
+		mkTok(TokenPunctuation, "=>"),
+		tSpace,
+		mkTok(TokenName, "a"),
+		tSpace,
+		mkTok(TokenOperator, ">="),
+		tSpace,
+		mkTok(TokenName, "b"),
+		tSpace,
+		tPrintClose,
+		tEOF,
+	}},
+
 	{"div and floordiv", "{{ 10 // 4 / 2 }}", []Token{
 		tPrintOpen,
 		tSpace,
@@ -320,6 +359,66 @@ var lexTests = []lexTest{
 		tCommentTrimClose,
 		tEOF,
 	}},
+
+	{"whitespace control trims text", "a \n {{- test -}} \n b", []Token{
+		mkTok(TokenText, "a"),
+		tPrintTrimOpen,
+		tSpace,
+		mkTok(TokenName, "test"),
+		tSpace,
+		tPrintTrimClose,
+		mkTok(TokenText, "b"),
+		tEOF,
+	}},
+
+	{"whitespace control removes empty text", "{% if a -%}\n\t{%- endif %}", []Token{
+		tTagOpen,
+		tSpace,
+		mkTok(TokenName, "if"),
+		tSpace,
+		mkTok(TokenName, "a"),
+		tSpace,
+		tTagTrimClose,
+		tTagTrimOpen,
+		tSpace,
+		mkTok(TokenName, "endif"),
+		tSpace,
+		tTagClose,
+		tEOF,
+	}},
+
+	{"line whitespace control print", "a \n {{~ test ~}} \n b", []Token{
+		mkTok(TokenText, "a \n"),
+		tPrintLineOpen,
+		tSpace,
+		mkTok(TokenName, "test"),
+		tSpace,
+		tPrintLineClose,
+		mkTok(TokenText, "\n b"),
+		tEOF,
+	}},
+
+	{"line whitespace control tag", "\t{%~ test ~%}\t", []Token{
+		tTagLineOpen,
+		tSpace,
+		mkTok(TokenName, "test"),
+		tSpace,
+		tTagLineClose,
+		tEOF,
+	}},
+
+	{"concat is not a whitespace modifier", "{{ a ~ b ~}}", []Token{
+		tPrintOpen,
+		tSpace,
+		mkTok(TokenName, "a"),
+		tSpace,
+		mkTok(TokenOperator, "~"),
+		tSpace,
+		mkTok(TokenName, "b"),
+		tSpace,
+		tPrintLineClose,
+		tEOF,
+	}},
 }
 
 func collect(t *lexTest) (tokens []Token) {
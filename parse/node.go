@@ -15,9 +15,35 @@ type Node interface {
 
 // A TrimmableNode contains information on whether preceding or trailing whitespace should
 // be removed when executing the template.
+//
+// The whitespace itself is removed from adjacent text as the template is lexed, so
+// executing the template requires no additional work.
 type TrimmableNode struct {
-	TrimBefore bool // True if whitespace before the node should be removed.
-	TrimAfter  bool // True if whitespace after the node should be removed.
+	TrimBefore     bool // True if whitespace before the node should be removed.
+	TrimAfter      bool // True if whitespace after the node should be removed.
+	TrimLineBefore bool // True if spaces and tabs, but not newlines, before the node should be removed.
+	TrimLineAfter  bool // True if spaces and tabs, but not newlines, after the node should be removed.
+}
+
+// trimmable returns the TrimmableNode so the parser can set whitespace control flags.
+func (t *TrimmableNode) trimmable() *TrimmableNode {
+	return t
+}
+
+// setTrim sets whitespace control flags based on the given opening and closing Tokens.
+func (t *TrimmableNode) setTrim(open, close Token) {
+	switch {
+	case strings.HasSuffix(open.value, delimTrimWhitespace):
+		t.TrimBefore = true
+	case strings.HasSuffix(open.value, delimTrimLineSpace):
+		t.TrimLineBefore = true
+	}
+	switch {
+	case strings.HasPrefix(close.value, delimTrimWhitespace):
+		t.TrimAfter = true
+	case strings.HasPrefix(close.value, delimTrimLineSpace):
+		t.TrimLineAfter = true
+	}
 }
 
 // Pos is used to track line and offset in a given string.
@@ -364,6 +390,9 @@ func (t *DoNode) All() []Node {
 }
 
 // FilterNode represents a block of filtered data.
+//
+// Deprecated: The filter tag is parsed as an ApplyNode, which supports
+// filter arguments.
 type FilterNode struct {
 	Pos
 	TrimmableNode
@@ -386,6 +415,195 @@ func (t *FilterNode) All() []Node {
 	return []Node{t.Body}
 }
 
+// ApplyNode represents an apply tag, which applies filters to the output of
+// its body.
+//
+// The Args of each filter do not include the value being filtered, which is
+// the output of Body for the first filter, and the result of the previous
+// filter for the rest.
+type ApplyNode struct {
+	Pos
+	TrimmableNode
+	Tag     string        // The name of the tag, "apply" or the deprecated "filter".
+	Filters []*FilterExpr // Filters to apply to Body, in order.
+	Body    Node          // Body of the apply tag.
+}
+
+// NewApplyNode creates an ApplyNode.
+func NewApplyNode(tag string, filters []*FilterExpr, body Node, p Pos) *ApplyNode {
+	return &ApplyNode{p, TrimmableNode{}, tag, filters, body}
+}
+
+// String returns a string representation of an ApplyNode.
+func (t *ApplyNode) String() string {
+	filters := make([]string, len(t.Filters))
+	for i, f := range t.Filters {
+		filters[i] = f.String()
+	}
+	return fmt.Sprintf("Apply (%s): %s", strings.Join(filters, "|"), t.Body)
+}
+
+// All returns all the child Nodes in an ApplyNode.
+func (t *ApplyNode) All() []Node {
+	res := make([]Node, 0, len(t.Filters)+1)
+	for _, f := range t.Filters {
+		res = append(res, f)
+	}
+	return append(res, t.Body)
+}
+
+// SpacelessNode represents a spaceless tag, which removes whitespace between
+// HTML tags in the output of its body.
+type SpacelessNode struct {
+	Pos
+	TrimmableNode
+	Body Node // Body of the spaceless tag.
+}
+
+// NewSpacelessNode returns a SpacelessNode.
+func NewSpacelessNode(body Node, pos Pos) *SpacelessNode {
+	return &SpacelessNode{pos, TrimmableNode{}, body}
+}
+
+// String returns a string representation of a SpacelessNode.
+func (t *SpacelessNode) String() string {
+	return fmt.Sprintf("Spaceless: %s", t.Body)
+}
+
+// All returns all the child Nodes in a SpacelessNode.
+func (t *SpacelessNode) All() []Node {
+	return []Node{t.Body}
+}
+
+// CacheNode represents a cache tag, which caches the output of its body.
+type CacheNode struct {
+	Pos
+	TrimmableNode
+	Key  Expr // Key identifying the cached output.
+	TTL  Expr // Number of seconds to cache the output for, or nil.
+	Tags Expr // Tag or list of tags used to invalidate the cached output, or nil.
+	Body Node // Body of the cache tag.
+}
+
+// NewCacheNode returns a CacheNode.
+func NewCacheNode(key, ttl, tags Expr, body Node, pos Pos) *CacheNode {
+	return &CacheNode{pos, TrimmableNode{}, key, ttl, tags, body}
+}
+
+// String returns a string representation of a CacheNode.
+func (t *CacheNode) String() string {
+	return fmt.Sprintf("Cache(%s ttl(%v) tags(%v)): %s", t.Key, t.TTL, t.Tags, t.Body)
+}
+
+// All returns all the child Nodes in a CacheNode.
+func (t *CacheNode) All() []Node {
+	res := []Node{t.Key}
+	if t.TTL != nil {
+		res = append(res, t.TTL)
+	}
+	if t.Tags != nil {
+		res = append(res, t.Tags)
+	}
+	return append(res, t.Body)
+}
+
+// DeprecatedNode represents a deprecated tag, which marks the template, or
+// the macro it is in, as deprecated.
+type DeprecatedNode struct {
+	Pos
+	TrimmableNode
+	Message Expr // Message describing the deprecation.
+}
+
+// NewDeprecatedNode returns a DeprecatedNode.
+func NewDeprecatedNode(msg Expr, pos Pos) *DeprecatedNode {
+	return &DeprecatedNode{pos, TrimmableNode{}, msg}
+}
+
+// String returns a string representation of a DeprecatedNode.
+func (t *DeprecatedNode) String() string {
+	return fmt.Sprintf("Deprecated(%s)", t.Message)
+}
+
+// All returns all the child Nodes in a DeprecatedNode.
+func (t *DeprecatedNode) All() []Node {
+	return []Node{t.Message}
+}
+
+// AutoEscapeNode represents an autoescape tag, which sets the escaping
+// strategy used within its body.
+type AutoEscapeNode struct {
+	Pos
+	TrimmableNode
+	Strategy string // Escaping strategy, or an empty string if escaping is disabled.
+	Body     Node   // Body of the autoescape tag.
+}
+
+// NewAutoEscapeNode returns an AutoEscapeNode.
+func NewAutoEscapeNode(strategy string, body Node, pos Pos) *AutoEscapeNode {
+	return &AutoEscapeNode{pos, TrimmableNode{}, strategy, body}
+}
+
+// String returns a string representation of an AutoEscapeNode.
+func (t *AutoEscapeNode) String() string {
+	return fmt.Sprintf("AutoEscape(%q): %s", t.Strategy, t.Body)
+}
+
+// All returns all the child Nodes in an AutoEscapeNode.
+func (t *AutoEscapeNode) All() []Node {
+	return []Node{t.Body}
+}
+
+// WithNode represents a with tag, which executes its body in a new scope.
+type WithNode struct {
+	Pos
+	TrimmableNode
+	With Expr // Expression evaluating to a hash of variables to define, or nil.
+	Only bool // If true, variables from the enclosing scope are not available.
+	Body Node // Body of the with tag.
+}
+
+// NewWithNode returns a WithNode.
+func NewWithNode(with Expr, only bool, body Node, pos Pos) *WithNode {
+	return &WithNode{pos, TrimmableNode{}, with, only, body}
+}
+
+// String returns a string representation of a WithNode.
+func (t *WithNode) String() string {
+	return fmt.Sprintf("With(%v %v): %s", t.With, t.Only, t.Body)
+}
+
+// All returns all the child Nodes in a WithNode.
+func (t *WithNode) All() []Node {
+	if t.With == nil {
+		return []Node{t.Body}
+	}
+	return []Node{t.With, t.Body}
+}
+
+// SandboxNode represents a sandbox tag. Templates included within its body
+// are executed in sandbox mode.
+type SandboxNode struct {
+	Pos
+	TrimmableNode
+	Body *BodyNode // Body of the sandbox tag, containing only include tags.
+}
+
+// NewSandboxNode returns a SandboxNode.
+func NewSandboxNode(body *BodyNode, p Pos) *SandboxNode {
+	return &SandboxNode{p, TrimmableNode{}, body}
+}
+
+// String returns a string representation of a SandboxNode.
+func (t *SandboxNode) String() string {
+	return fmt.Sprintf("Sandbox: %s", t.Body)
+}
+
+// All returns all the child Nodes in a SandboxNode.
+func (t *SandboxNode) All() []Node {
+	return []Node{t.Body}
+}
+
 // MacroNode represents a reusable macro.
 type MacroNode struct {
 	Pos
@@ -394,21 +612,36 @@ type MacroNode struct {
 	Args   []string  // Args the macro receives.
 	Body   *BodyNode // Body of the macro.
 	Origin string    // The name where this macro is originally defined.
+
+	Defaults map[string]Expr // Default values of optional args, keyed by name.
 }
 
 // NewMacroNode returns a MacroNode.
 func NewMacroNode(name string, args []string, body *BodyNode, p Pos) *MacroNode {
-	return &MacroNode{p, TrimmableNode{}, name, args, body, ""}
+	return &MacroNode{p, TrimmableNode{}, name, args, body, "", make(map[string]Expr)}
 }
 
 // String returns a string representation of a MacroNode.
 func (t *MacroNode) String() string {
-	return fmt.Sprintf("Macro %s(%s): %s", t.Name, strings.Join(t.Args, ", "), t.Body)
+	args := make([]string, len(t.Args))
+	for i, name := range t.Args {
+		args[i] = name
+		if def, ok := t.Defaults[name]; ok {
+			args[i] = fmt.Sprintf("%s = %s", name, def)
+		}
+	}
+	return fmt.Sprintf("Macro %s(%s): %s", t.Name, strings.Join(args, ", "), t.Body)
 }
 
 // All returns all the child Nodes in a MacroNode.
 func (t *MacroNode) All() []Node {
-	return []Node{t.Body}
+	res := []Node{}
+	for _, name := range t.Args {
+		if def, ok := t.Defaults[name]; ok {
+			res = append(res, def)
+		}
+	}
+	return append(res, t.Body)
 }
 
 // ImportNode represents importing macros from another template.
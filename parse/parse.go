@@ -14,6 +14,10 @@ type NodeVisitor interface {
 }
 
 // Tree represents the state of a parser.
+//
+// A Tree must not be modified after Parse returns, though NodeVisitors may modify
+// nodes while the Tree is being parsed. A parsed Tree is safe for concurrent use
+// by multiple goroutines.
 type Tree struct {
 	lex *lexer
 
@@ -21,6 +25,9 @@ type Tree struct {
 	blocks []map[string]*BlockNode // Contains each block available to this template.
 	macros map[string]*MacroNode   // All macros defined on this template.
 
+	tags    map[string]Pos // Tags used in this template and the position of their first use.
+	filters map[string]Pos // Filters used in this template and the position of their first use.
+
 	unread []Token // Any tokens received by the lexer but not yet read.
 	read   []Token // Tokens that have already been read.
 
@@ -44,6 +51,9 @@ func NewNamedTree(name string, input io.Reader) *Tree {
 		blocks: []map[string]*BlockNode{make(map[string]*BlockNode)},
 		macros: make(map[string]*MacroNode),
 
+		tags:    make(map[string]Pos),
+		filters: make(map[string]Pos),
+
 		unread: make([]Token, 0),
 		read:   make([]Token, 0),
 
@@ -57,16 +67,41 @@ func (t *Tree) Root() *ModuleNode {
 	return t.root
 }
 
-// Blocks returns a map of blocks in this tree.
+// Blocks returns a map of blocks in this tree. The returned map must not be modified.
 func (t *Tree) Blocks() map[string]*BlockNode {
 	return t.blocks[len(t.blocks)-1]
 }
 
-// Macros returns a map of macros defined in this tree.
+// Macros returns a map of macros defined in this tree. The returned map must not be modified.
 func (t *Tree) Macros() map[string]*MacroNode {
 	return t.macros
 }
 
+// Tags returns the names of tags used in this tree and the position where each
+// is first used. The returned map must not be modified.
+func (t *Tree) Tags() map[string]Pos {
+	return t.tags
+}
+
+// Filters returns the names of filters used in this tree and the position where
+// each is first used. Filters added by a NodeVisitor are not included. The
+// returned map must not be modified.
+func (t *Tree) Filters() map[string]Pos {
+	return t.filters
+}
+
+func (t *Tree) addTag(name string, p Pos) {
+	if _, ok := t.tags[name]; !ok {
+		t.tags[name] = p
+	}
+}
+
+func (t *Tree) addFilter(name string, p Pos) {
+	if _, ok := t.filters[name]; !ok {
+		t.filters[name] = p
+	}
+}
+
 func (t *Tree) popBlockStack() map[string]*BlockNode {
 	blocks := t.Blocks()
 	t.blocks = t.blocks[0 : len(t.blocks)-1]
@@ -242,25 +277,29 @@ func (t *Tree) parse() (Node, error) {
 		if err != nil {
 			return nil, err
 		}
-		_, err = t.Expect(TokenPrintClose)
+		end, err := t.Expect(TokenPrintClose)
 		if err != nil {
 			return nil, err
 		}
-		return NewPrintNode(name, tok.Pos), nil
+		n := NewPrintNode(name, tok.Pos)
+		n.setTrim(tok, end)
+		return n, nil
 
 	case TokenTagOpen:
 		return t.parseTag()
 
 	case TokenCommentOpen:
-		tok, err := t.Expect(TokenText)
+		txt, err := t.Expect(TokenText)
 		if err != nil {
 			return nil, err
 		}
-		_, err = t.Expect(TokenCommentClose)
+		end, err := t.Expect(TokenCommentClose)
 		if err != nil {
 			return nil, err
 		}
-		return NewCommentNode(tok.value, tok.Pos), nil
+		n := NewCommentNode(txt.value, txt.Pos)
+		n.setTrim(tok, end)
+		return n, nil
 
 	case TokenEOF:
 		// expected end of input
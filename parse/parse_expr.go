@@ -12,6 +12,12 @@ func (t *Tree) ParseExpr() (Expr, error) {
 	return t.parseOuterExpr(expr)
 }
 
+// newFilterExpr returns a FilterExpr, recording the use of the named filter.
+func (t *Tree) newFilterExpr(name string, args []Expr, pos Pos) *FilterExpr {
+	t.addFilter(name, pos)
+	return NewFilterExpr(name, args, pos)
+}
+
 // parseOuterExpr attempts to parse an expression outside of an inner
 // expression.
 // An outer expression is defined as a modification to an inner expression.
@@ -67,35 +73,23 @@ func (t *Tree) parseOuterExpr(expr Expr) (Expr, error) {
 			return t.parseOuterExpr(NewGetAttrExpr(expr, attr, args, nt.Pos))
 
 		case "|": // Filter application
-			nx, err := t.ParseExpr()
+			name, err := t.Expect(TokenName)
 			if err != nil {
 				return nil, err
 			}
-			switch n := nx.(type) {
-			case *BinaryExpr:
-				switch b := n.Left.(type) {
-				case *NameExpr:
-					v := NewFilterExpr(b.Name, []Expr{expr}, nt.Pos)
-					n.Left = v
-					return n, nil
-				case *FuncExpr:
-					b.Args = append([]Expr{expr}, b.Args...)
-					v := NewFilterExpr(b.Name, b.Args, nt.Pos)
-					n.Left = v
-					return n, nil
-				default:
-					return nil, newUnexpectedTokenError(nt)
+			args := []Expr{expr}
+			pos := nt.Pos
+			if nxt := t.PeekNonSpace(); nxt.tokenType == TokenParensOpen {
+				t.NextNonSpace()
+				fn, err := t.parseFunc(NewNameExpr(name.value, name.Pos))
+				if err != nil {
+					return nil, err
 				}
-			case *NameExpr:
-				return NewFilterExpr(n.Name, []Expr{expr}, nt.Pos), nil
-
-			case *FuncExpr:
-				n.Args = append([]Expr{expr}, n.Args...)
-				return NewFilterExpr(n.Name, n.Args, n.Pos), nil
-
-			default:
-				return nil, newUnexpectedTokenError(nt)
+				args = append(args, fn.(*FuncExpr).Args...)
+				pos = name.Pos
 			}
+			// Continue with the filtered value, so that filters can be chained.
+			return t.parseOuterExpr(t.newFilterExpr(name.value, args, pos))
 
 		case "?": // Ternary if
 			tx, err := t.ParseExpr()
@@ -123,26 +117,26 @@ func (t *Tree) parseOuterExpr(expr Expr) (Expr, error) {
 			return nil, newUnexpectedTokenError(nt)
 		}
 
-		var right Node
-		var err error
 		if op.op == OpBinaryIs || op.op == OpBinaryIsNot {
-			right, err = t.parseRightTestOperand(nil)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			right, err = t.ParseExpr()
+			right, err := t.parseRightTestOperand(nil)
 			if err != nil {
 				return nil, err
 			}
-			if v, ok := right.(*BinaryExpr); ok {
-				nxop := binaryOperators[v.Op]
-				if nxop.precedence < op.precedence || (nxop.precedence == op.precedence && op.leftAssoc()) {
-					left := v.Left
-					res := NewBinaryExpr(expr, op.Operator(), left, expr.Start())
-					v.Left = res
-					return v, nil
-				}
+			// Continue after the test, such as with "and" or a ternary if.
+			return t.parseOuterExpr(NewBinaryExpr(expr, op.Operator(), right, expr.Start()))
+		}
+
+		right, err := t.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := right.(*BinaryExpr); ok {
+			nxop := binaryOperators[v.Op]
+			if nxop.precedence < op.precedence || (nxop.precedence == op.precedence && op.leftAssoc()) {
+				left := v.Left
+				res := NewBinaryExpr(expr, op.Operator(), left, expr.Start())
+				v.Left = res
+				return v, nil
 			}
 		}
 		return NewBinaryExpr(expr, op.Operator(), right, expr.Start()), nil
@@ -170,6 +164,10 @@ func (t *Tree) parseRightTestOperand(prev *NameExpr) (*TestExpr, error) {
 		}
 	}
 	switch r := right.(type) {
+	case *NullExpr:
+		// "null" and "none" are parsed as literals.
+		return NewTestExpr("null", []Expr{}, r.Pos), nil
+
 	case *NameExpr:
 		if prev != nil {
 			r.Name = prev.Name + " " + r.Name
@@ -205,6 +203,9 @@ func (t *Tree) parseInnerExpr() (Expr, error) {
 		return NewUnaryExpr(op.Operator(), expr, tok.Pos), nil
 
 	case TokenParensOpen:
+		if args, ok := t.parseArrowArgs(); ok {
+			return t.parseArrow(args, tok.Pos)
+		}
 		inner, err := t.ParseExpr()
 		if err != nil {
 			return nil, err
@@ -297,6 +298,8 @@ func (t *Tree) parseInnerExpr() (Expr, error) {
 		if nt.tokenType == TokenParensOpen {
 			// TODO: This duplicates some code in parseOuterExpr, are both necessary?
 			return t.parseFunc(name)
+		} else if nt.tokenType == TokenPunctuation && nt.value == delimArrow {
+			return t.parseArrow([]string{name.Name}, tok.Pos)
 		}
 		t.backup()
 		return name, nil
@@ -343,9 +346,75 @@ func (t *Tree) parseInnerExpr() (Expr, error) {
 	}
 }
 
+// parseArrowArgs attempts to parse the argument list of an arrow function,
+// following an opening parenthesis:
+//
+//	(a, b) => a + b
+//
+// If the argument list is not followed by "=>", the tokens are left unread
+// and ok is false.
+func (t *Tree) parseArrowArgs() (args []string, ok bool) {
+	start := len(t.read)
+	defer func() {
+		if !ok {
+			for len(t.read) > start {
+				t.backup()
+			}
+		}
+	}()
+	tok := t.NextNonSpace()
+	for tok.tokenType == TokenName {
+		args = append(args, tok.value)
+		tok = t.NextNonSpace()
+		if tok.tokenType != TokenPunctuation || tok.value != "," {
+			break
+		}
+		tok = t.NextNonSpace()
+	}
+	if tok.tokenType != TokenParensClose {
+		return nil, false
+	}
+	tok = t.NextNonSpace()
+	return args, tok.tokenType == TokenPunctuation && tok.value == delimArrow
+}
+
+// parseArrow parses the body of an arrow function with the given arguments.
+func (t *Tree) parseArrow(args []string, pos Pos) (Expr, error) {
+	body, err := t.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return NewArrowExpr(args, body, pos), nil
+}
+
+// parseNamedArg attempts to parse a named argument, such as:
+//
+//	pretty=true
+//
+// If the next tokens are not a name followed by "=", they are left unread
+// and ok is false.
+func (t *Tree) parseNamedArg() (exp Expr, ok bool, err error) {
+	start := len(t.read)
+	name := t.NextNonSpace()
+	if name.tokenType == TokenName {
+		if tok := t.NextNonSpace(); tok.tokenType == TokenPunctuation && tok.value == "=" {
+			val, err := t.ParseExpr()
+			if err != nil {
+				return nil, true, err
+			}
+			return NewNamedArgExpr(name.value, val, name.Pos), true, nil
+		}
+	}
+	for len(t.read) > start {
+		t.backup()
+	}
+	return nil, false, nil
+}
+
 // parseFunc parses a function call expression from the first argument expression until the closing parenthesis.
 func (t *Tree) parseFunc(name *NameExpr) (Expr, error) {
 	var args []Expr
+	named := false
 	for {
 		switch tok := t.Peek(); tok.tokenType {
 		case TokenEOF:
@@ -355,10 +424,21 @@ func (t *Tree) parseFunc(name *NameExpr) (Expr, error) {
 		// do nothing
 
 		default:
-			argexp, err := t.ParseExpr()
+			argexp, ok, err := t.parseNamedArg()
 			if err != nil {
 				return nil, err
 			}
+			if ok {
+				named = true
+			} else {
+				if named {
+					return nil, newPositionalArgError(t.PeekNonSpace().Pos)
+				}
+				argexp, err = t.ParseExpr()
+				if err != nil {
+					return nil, err
+				}
+			}
 
 			args = append(args, argexp)
 		}
@@ -3,19 +3,52 @@ package parse
 import (
 	"bytes"
 	"errors"
+	"strings"
 )
 
 // A TagParser can parse the body of a tag, returning the resulting Node or an error.
 // TODO: This will be used to implement user-defined tags.
 type TagParser func(t *Tree, start Pos) (Node, error)
 
+// A trimmableNode is a Node that supports whitespace control.
+type trimmableNode interface {
+	trimmable() *TrimmableNode
+}
+
 // parseTag parses the opening of a tag "{%", then delegates to a more specific parser function
-// based on the tag's name.
+// based on the tag's name. Whitespace control flags are set on the resulting Node, if supported.
 func (t *Tree) parseTag() (Node, error) {
+	var open, end Token
+	for i := len(t.read) - 1; i >= 0; i-- {
+		if tok := t.read[i]; tok.tokenType != TokenWhitespace {
+			open = tok
+			break
+		}
+	}
+	n, err := t.parseTagName()
+	if err != nil {
+		return nil, err
+	}
+	if l := len(t.read); l > 0 && t.read[l-1].tokenType == TokenTagClose {
+		end = t.read[l-1]
+	}
+	if tn, ok := n.(trimmableNode); ok && open.tokenType == TokenTagOpen {
+		tn.trimmable().setTrim(open, end)
+	}
+	return n, nil
+}
+
+// parseTagName delegates to a more specific parser function based on the tag's name.
+func (t *Tree) parseTagName() (Node, error) {
 	name, err := t.Expect(TokenName)
 	if err != nil {
 		return nil, err
 	}
+	if name.value == "elseif" {
+		t.addTag("if", name.Pos)
+	} else {
+		t.addTag(name.value, name.Pos)
+	}
 	switch name.value {
 	case "extends":
 		return parseExtends(t, name.Pos)
@@ -35,8 +68,8 @@ func (t *Tree) parseTag() (Node, error) {
 		return parseSet(t, name.Pos)
 	case "do":
 		return parseDo(t, name.Pos)
-	case "filter":
-		return parseFilter(t, name.Pos)
+	case "apply", "filter":
+		return parseApply(t, name.value, name.Pos)
 	case "macro":
 		return parseMacro(t, name.Pos)
 	case "import":
@@ -45,6 +78,18 @@ func (t *Tree) parseTag() (Node, error) {
 		return parseFrom(t, name.Pos)
 	case "verbatim":
 		return parseVerbatim(t, name.Pos)
+	case "sandbox":
+		return parseSandbox(t, name.Pos)
+	case "with":
+		return parseWith(t, name.Pos)
+	case "autoescape":
+		return parseAutoEscape(t, name.Pos)
+	case "spaceless":
+		return parseSpaceless(t, name.Pos)
+	case "deprecated":
+		return parseDeprecated(t, name.Pos)
+	case "cache":
+		return parseCache(t, name.Pos)
 	default:
 		// Support user-defined parsers
 		if p, ok := t.Parsers[name.value]; ok {
@@ -531,22 +576,32 @@ func parseDo(t *Tree, start Pos) (Node, error) {
 	return NewDoNode(expr, start), nil
 }
 
-// parseFilter parses a filter statement.
+// parseApply parses an apply statement, or a filter statement, which is
+// a deprecated alias.
 //
-//	{% filter <name> %}
+//	{% apply <name>[(<args>)] %}
 //
 // Multiple filters can be applied to a block:
 //
-//	{% filter <name>|<name>|<name> %}
-func parseFilter(t *Tree, start Pos) (Node, error) {
-	var filters []string
+//	{% apply <name>[(<args>)]|<name>[(<args>)] %}
+func parseApply(t *Tree, tag string, start Pos) (Node, error) {
+	var filters []*FilterExpr
 	for {
 		tok, err := t.Expect(TokenName)
 		if err != nil {
 			return nil, err
 		}
-		filters = append(filters, tok.value)
-		tok = t.PeekNonSpace()
+		var args []Expr
+		if nxt := t.PeekNonSpace(); nxt.tokenType == TokenParensOpen {
+			t.NextNonSpace()
+			fn, err := t.parseFunc(NewNameExpr(tok.value, tok.Pos))
+			if err != nil {
+				return nil, err
+			}
+			args = fn.(*FuncExpr).Args
+		}
+		filters = append(filters, t.newFilterExpr(tok.value, args, tok.Pos))
+		tok = t.NextNonSpace()
 		switch tok.tokenType {
 		case TokenEOF:
 			return nil, newUnexpectedEOFError(tok)
@@ -554,23 +609,23 @@ func parseFilter(t *Tree, start Pos) (Node, error) {
 			if tok.value != "|" {
 				return nil, newUnexpectedValueError(tok, "|")
 			}
-			t.NextNonSpace()
 		case TokenTagClose:
-			t.NextNonSpace()
 			goto body
+		default:
+			return nil, newUnexpectedTokenError(tok)
 		}
 	}
 body:
-	body, err := t.ParseUntilEndTag("filter", start)
+	body, err := t.ParseUntilEndTag(tag, start)
 	if err != nil {
 		return nil, err
 	}
-	return NewFilterNode(filters, body, start), nil
+	return NewApplyNode(tag, filters, body, start), nil
 }
 
 // parseMacro parses a macro definition.
 //
-//	{% macro <name>([ arg [ , arg]) %}
+//	{% macro <name>([ arg [ = <default> ] [ , arg [ = <default> ] ]) %}
 //	Macro body
 //	{% endmacro %}
 func parseMacro(t *Tree, start Pos) (Node, error) {
@@ -584,6 +639,7 @@ func parseMacro(t *Tree, start Pos) (Node, error) {
 		return nil, err
 	}
 	var args []string
+	defaults := make(map[string]Expr)
 	for {
 		tok = t.NextNonSpace()
 		switch tok.tokenType {
@@ -591,6 +647,14 @@ func parseMacro(t *Tree, start Pos) (Node, error) {
 			return nil, newUnexpectedEOFError(tok)
 		case TokenName:
 			args = append(args, tok.value)
+			if nxt := t.PeekNonSpace(); nxt.tokenType == TokenPunctuation && nxt.value == "=" {
+				t.NextNonSpace()
+				def, err := t.ParseExpr()
+				if err != nil {
+					return nil, err
+				}
+				defaults[tok.value] = def
+			}
 		case TokenPunctuation:
 			if tok.value != "," {
 				return nil, newUnexpectedValueError(tok, ",")
@@ -611,6 +675,7 @@ body:
 		return nil, err
 	}
 	n := NewMacroNode(name, args, body, start)
+	n.Defaults = defaults
 	n.Origin = t.Name
 	t.macros[name] = n
 	return n, nil
@@ -718,3 +783,176 @@ func parseVerbatim(t *Tree, start Pos) (Node, error) {
 		}
 	}
 }
+
+// parseWith parses a with tag.
+//
+//	{% with [<expr> [only]] %}
+//	Body
+//	{% endwith %}
+func parseWith(t *Tree, start Pos) (Node, error) {
+	var with Expr
+	only := false
+	if tok := t.PeekNonSpace(); tok.tokenType != TokenTagClose {
+		var err error
+		with, err = t.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := t.PeekNonSpace(); tok.tokenType == TokenName && tok.value == "only" {
+			t.NextNonSpace()
+			only = true
+		}
+	}
+	_, err := t.Expect(TokenTagClose)
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.ParseUntilEndTag("with", start)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithNode(with, only, body, start), nil
+}
+
+// parseCache parses a cache tag. The ttl and tags modifiers are optional.
+//
+//	{% cache <expr> [ttl(<expr>)] [tags(<expr>)] %}
+//	Body
+//	{% endcache %}
+func parseCache(t *Tree, start Pos) (Node, error) {
+	key, err := t.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	var ttl, tags Expr
+	for {
+		tok := t.NextNonSpace()
+		if tok.tokenType == TokenTagClose {
+			break
+		}
+		var mod *Expr
+		switch {
+		case tok.tokenType == TokenName && tok.value == "ttl" && ttl == nil:
+			mod = &ttl
+		case tok.tokenType == TokenName && tok.value == "tags" && tags == nil:
+			mod = &tags
+		default:
+			return nil, newUnexpectedTokenError(tok, TokenTagClose)
+		}
+		_, err = t.Expect(TokenParensOpen)
+		if err != nil {
+			return nil, err
+		}
+		*mod, err = t.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = t.Expect(TokenParensClose)
+		if err != nil {
+			return nil, err
+		}
+	}
+	body, err := t.ParseUntilEndTag("cache", start)
+	if err != nil {
+		return nil, err
+	}
+	return NewCacheNode(key, ttl, tags, body, start), nil
+}
+
+// parseDeprecated parses a deprecated tag.
+//
+//	{% deprecated <expr> %}
+func parseDeprecated(t *Tree, start Pos) (Node, error) {
+	msg, err := t.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.Expect(TokenTagClose)
+	if err != nil {
+		return nil, err
+	}
+	return NewDeprecatedNode(msg, start), nil
+}
+
+// parseSpaceless parses a spaceless tag, which removes whitespace between
+// HTML tags in the output of the body.
+//
+//	{% spaceless %}
+//	Body
+//	{% endspaceless %}
+func parseSpaceless(t *Tree, start Pos) (Node, error) {
+	_, err := t.Expect(TokenTagClose)
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.ParseUntilEndTag("spaceless", start)
+	if err != nil {
+		return nil, err
+	}
+	return NewSpacelessNode(body, start), nil
+}
+
+// parseAutoEscape parses an autoescape tag. The strategy defaults to "html",
+// and false disables escaping within the body.
+//
+//	{% autoescape [<string>|false] %}
+//	Body
+//	{% endautoescape %}
+func parseAutoEscape(t *Tree, start Pos) (Node, error) {
+	strategy := "html"
+	if tok := t.PeekNonSpace(); tok.tokenType != TokenTagClose {
+		expr, err := t.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		switch e := expr.(type) {
+		case *StringExpr:
+			strategy = e.Text
+		case *BoolExpr:
+			if e.Value {
+				return nil, newInvalidEscapeStrategyError(e.Pos)
+			}
+			strategy = ""
+		default:
+			return nil, newInvalidEscapeStrategyError(expr.Start())
+		}
+	}
+	_, err := t.Expect(TokenTagClose)
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.ParseUntilEndTag("autoescape", start)
+	if err != nil {
+		return nil, err
+	}
+	return NewAutoEscapeNode(strategy, body, start), nil
+}
+
+// parseSandbox parses a sandbox tag. Only include tags are allowed within
+// the body of a sandbox tag.
+//
+//	{% sandbox %}
+//	{% include <expr> %}
+//	{% endsandbox %}
+func parseSandbox(t *Tree, start Pos) (Node, error) {
+	_, err := t.Expect(TokenTagClose)
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.ParseUntilEndTag("sandbox", start)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range body.All() {
+		switch c := n.(type) {
+		case *IncludeNode:
+			continue
+		case *TextNode:
+			if strings.TrimSpace(c.Data) == "" {
+				continue
+			}
+		}
+		return nil, newInvalidSandboxError(n.Start())
+	}
+	return NewSandboxNode(body, start), nil
+}
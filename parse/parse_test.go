@@ -38,6 +38,7 @@ var parseTests = []parseTest{
 	newErrorTest("unexpected end (function call)", "{{ func('arg1'", `unexpected end of input on line 1, column 14`),
 	newErrorTest("unclosed parenthesis", "{{ func(arg1 }}", `expected one of [PUNCTUATION, PARENS_CLOSE], got "ERROR" on line 1, column 13`),
 	newErrorTest("unexpected punctuation", "{{ func(arg1? arg2) }}", `expected "PUNCTUATION", got "PARENS_CLOSE"`),
+	newErrorTest("positional after named argument", "{{ func(a=1, 2) }}", `positional arguments cannot be used after named arguments on line 1, column 13`),
 
 	// Valid
 	newParseTest("text", "some text", mkModule(NewTextNode("some text", noPos))),
@@ -143,6 +144,36 @@ var parseTests = []parseTest{
 		"{{ 10 ** 2 ** 5 }}",
 		mkModule(NewPrintNode(NewBinaryExpr(NewNumberExpr("10", noPos), OpBinaryPower, NewBinaryExpr(NewNumberExpr("2", noPos), OpBinaryPower, NewNumberExpr("5", noPos), noPos), noPos), noPos)),
 	),
+	newParseTest(
+		"null coalesce precedence and associativity",
+		"{{ a ?? b ?? c ~ 'x' }}",
+		mkModule(NewPrintNode(NewBinaryExpr(NewBinaryExpr(NewNameExpr("a", noPos), OpBinaryNullCoalesce, NewBinaryExpr(NewNameExpr("b", noPos), OpBinaryNullCoalesce, NewNameExpr("c", noPos), noPos), noPos), OpBinaryConcat, NewStringExpr("x", noPos), noPos), noPos)),
+	),
+	newParseTest(
+		"named arguments",
+		"{{ func(1, b = 'x', c=d == e)|filter(x=1) }}",
+		mkModule(NewPrintNode(NewFilterExpr("filter", []Expr{NewFuncExpr("func", []Expr{NewNumberExpr("1", noPos), NewNamedArgExpr("b", NewStringExpr("x", noPos), noPos), NewNamedArgExpr("c", NewBinaryExpr(NewNameExpr("d", noPos), OpBinaryEqual, NewNameExpr("e", noPos), noPos), noPos)}, noPos), NewNamedArgExpr("x", NewNumberExpr("1", noPos), noPos)}, noPos), noPos)),
+	),
+	newParseTest(
+		"chained filters",
+		"{{ name|lower|replace({'a': 'b'}) ~ '!' }}",
+		mkModule(NewPrintNode(NewBinaryExpr(NewFilterExpr("replace", []Expr{NewFilterExpr("lower", []Expr{NewNameExpr("name", noPos)}, noPos), NewHashExpr(noPos, NewKeyValueExpr(NewStringExpr("a", noPos), NewStringExpr("b", noPos), noPos))}, noPos), OpBinaryConcat, NewStringExpr("!", noPos), noPos), noPos)),
+	),
+	newParseTest(
+		"arrow function",
+		"{{ items|map(i => i.name ~ '!') }}",
+		mkModule(NewPrintNode(NewFilterExpr("map", []Expr{NewNameExpr("items", noPos), NewArrowExpr([]string{"i"}, NewBinaryExpr(NewGetAttrExpr(NewNameExpr("i", noPos), NewStringExpr("name", noPos), nil, noPos), OpBinaryConcat, NewStringExpr("!", noPos), noPos), noPos)}, noPos), noPos)),
+	),
+	newParseTest(
+		"arrow function with multiple arguments",
+		"{{ items|reduce((carry, i) => carry + i, 0) }}",
+		mkModule(NewPrintNode(NewFilterExpr("reduce", []Expr{NewNameExpr("items", noPos), NewArrowExpr([]string{"carry", "i"}, NewBinaryExpr(NewNameExpr("carry", noPos), OpBinaryAdd, NewNameExpr("i", noPos), noPos), noPos), NewNumberExpr("0", noPos)}, noPos), noPos)),
+	),
+	newParseTest(
+		"arrow function without arguments",
+		"{{ ( ) => (a) }}",
+		mkModule(NewPrintNode(NewArrowExpr(nil, NewGroupExpr(NewNameExpr("a", noPos), noPos), noPos), noPos)),
+	),
 	newParseTest(
 		"extended binary expression",
 		"{{ 5 + 10 + 15 * 12 / 4 }}",
@@ -238,6 +269,11 @@ var parseTests = []parseTest{
 		"{{ animal is mammal }}{{ 10 is not divisible by(3) }}",
 		mkModule(NewPrintNode(NewBinaryExpr(NewNameExpr("animal", noPos), OpBinaryIs, NewTestExpr("mammal", []Expr{}, noPos), noPos), noPos), NewPrintNode(NewBinaryExpr(NewNumberExpr("10", noPos), OpBinaryIsNot, NewTestExpr("divisible by", []Expr{NewNumberExpr("3", noPos)}, noPos), noPos), noPos)),
 	),
+	newParseTest(
+		"test followed by an expression",
+		"{{ a is defined and b is null }}{{ a is defined ? 1 : 2 }}",
+		mkModule(NewPrintNode(NewBinaryExpr(NewBinaryExpr(NewNameExpr("a", noPos), OpBinaryIs, NewTestExpr("defined", []Expr{}, noPos), noPos), OpBinaryAnd, NewBinaryExpr(NewNameExpr("b", noPos), OpBinaryIs, NewTestExpr("null", []Expr{}, noPos), noPos), noPos), noPos), NewPrintNode(NewTernaryIfExpr(NewBinaryExpr(NewNameExpr("a", noPos), OpBinaryIs, NewTestExpr("defined", []Expr{}, noPos), noPos), NewNumberExpr("1", noPos), NewNumberExpr("2", noPos), noPos), noPos)),
+	),
 	newParseTest(
 		"comment",
 		"But{# This is a test #} not this.",
@@ -276,8 +312,77 @@ var parseTests = []parseTest{
 	newParseTest(
 		"filter statement",
 		"{% filter upper|escape %}Some text{% endfilter %}",
-		mkModule(NewFilterNode([]string{"upper", "escape"}, NewBodyNode(noPos, NewTextNode("Some text", noPos)), noPos)),
+		mkModule(NewApplyNode("filter", []*FilterExpr{NewFilterExpr("upper", nil, noPos), NewFilterExpr("escape", nil, noPos)}, NewBodyNode(noPos, NewTextNode("Some text", noPos)), noPos)),
+	),
+	newParseTest(
+		"apply statement",
+		"{% apply upper|replace({'a': 'b'})|trim(side='left') %}Some text{% endapply %}",
+		mkModule(NewApplyNode("apply", []*FilterExpr{
+			NewFilterExpr("upper", nil, noPos),
+			NewFilterExpr("replace", []Expr{NewHashExpr(noPos, NewKeyValueExpr(NewStringExpr("a", noPos), NewStringExpr("b", noPos), noPos))}, noPos),
+			NewFilterExpr("trim", []Expr{NewNamedArgExpr("side", NewStringExpr("left", noPos), noPos)}, noPos),
+		}, NewBodyNode(noPos, NewTextNode("Some text", noPos)), noPos)),
+	),
+	newParseTest(
+		"cache statement",
+		"{% cache 'nav' %}a{% endcache %}{% cache key tags(['nav', 'menu']) ttl(300) %}b{% endcache %}",
+		mkModule(
+			NewCacheNode(NewStringExpr("nav", noPos), nil, nil, NewBodyNode(noPos, NewTextNode("a", noPos)), noPos),
+			NewCacheNode(
+				NewNameExpr("key", noPos),
+				NewNumberExpr("300", noPos),
+				NewArrayExpr(noPos, NewStringExpr("nav", noPos), NewStringExpr("menu", noPos)),
+				NewBodyNode(noPos, NewTextNode("b", noPos)),
+				noPos,
+			),
+		),
+	),
+	newErrorTest("cache unknown modifier", "{% cache 'nav' ttl(1) foo(1) %}{% endcache %}", `expected "TAG_CLOSE", got "NAME" on line 1, column 22`),
+	newErrorTest("cache duplicate modifier", "{% cache 'nav' ttl(1) ttl(2) %}{% endcache %}", `expected "TAG_CLOSE", got "NAME" on line 1, column 22`),
+	newErrorTest("unclosed cache", "{% cache 'nav' %}", `unclosed tag "cache" starting on line 1, column 3`),
+	newParseTest(
+		"deprecated statement",
+		"{% deprecated 'use new.twig instead' %}",
+		mkModule(NewDeprecatedNode(NewStringExpr("use new.twig instead", noPos), noPos)),
+	),
+	newErrorTest("deprecated without message", "{% deprecated %}", `unexpected Token "TAG_CLOSE" on line 1, column 14`),
+	newParseTest(
+		"spaceless statement",
+		"{% spaceless %}<p> </p>{% endspaceless %}",
+		mkModule(NewSpacelessNode(NewBodyNode(noPos, NewTextNode("<p> </p>", noPos)), noPos)),
+	),
+	newErrorTest("spaceless with arguments", "{% spaceless foo %}{% endspaceless %}", `expected "TAG_CLOSE", got "NAME" on line 1, column 13`),
+	newErrorTest("apply without filter", "{% apply %}text{% endapply %}", `expected "NAME", got "TAG_CLOSE" on line 1, column 9`),
+	newErrorTest("apply with expression", "{% apply upper ~ 'a' %}text{% endapply %}", `unexpected Token "OPERATOR" on line 1, column 15`),
+	newErrorTest("unclosed apply", "{% apply upper %}", `unclosed tag "apply" starting on line 1, column 3`),
+	newParseTest(
+		"with statement",
+		"{% with %}{{ foo }}{% endwith %}",
+		mkModule(NewWithNode(nil, false, NewBodyNode(noPos, NewPrintNode(NewNameExpr("foo", noPos), noPos)), noPos)),
+	),
+	newParseTest(
+		"with statement only",
+		"{% with {foo: 42} only %}{{ foo }}{% endwith %}",
+		mkModule(NewWithNode(
+			NewHashExpr(noPos, NewKeyValueExpr(NewNameExpr("foo", noPos), NewNumberExpr("42", noPos), noPos)),
+			true,
+			NewBodyNode(noPos, NewPrintNode(NewNameExpr("foo", noPos), noPos)),
+			noPos,
+		)),
+	),
+	newErrorTest("with unexpected token", "{% with vars foo %}{% endwith %}", `expected "TAG_CLOSE", got "NAME" on line 1, column 13`),
+	newErrorTest("unclosed with", "{% with vars %}", `unclosed tag "with" starting on line 1, column 3`),
+	newParseTest(
+		"autoescape statement",
+		"{% autoescape %}{{ a }}{% endautoescape %}{% autoescape 'js' %}{{ b }}{% endautoescape %}{% autoescape false %}{{ c }}{% endautoescape %}",
+		mkModule(
+			NewAutoEscapeNode("html", NewBodyNode(noPos, NewPrintNode(NewNameExpr("a", noPos), noPos)), noPos),
+			NewAutoEscapeNode("js", NewBodyNode(noPos, NewPrintNode(NewNameExpr("b", noPos), noPos)), noPos),
+			NewAutoEscapeNode("", NewBodyNode(noPos, NewPrintNode(NewNameExpr("c", noPos), noPos)), noPos),
+		),
 	),
+	newErrorTest("autoescape invalid strategy", "{% autoescape strategy %}{% endautoescape %}", `an escaping strategy must be a string or false on line 1, column 14`),
+	newErrorTest("unclosed autoescape", "{% autoescape 'js' %}", `unclosed tag "autoescape" starting on line 1, column 3`),
 	newParseTest(
 		"simple macro",
 		"{% macro thing(var1, var2) %}Hello{% endmacro %}",
@@ -288,6 +393,16 @@ var parseTests = []parseTest{
 		"{% macro thing(var2) %}Hello{% endmacro %}",
 		mkModule(NewMacroNode("thing", []string{"var2"}, NewBodyNode(noPos, NewTextNode("Hello", noPos)), noPos)),
 	),
+	newParseTest(
+		"macro with default values",
+		"{% macro input(name, type = 'text', size=20) %}{{ type }}{% endmacro %}",
+		mkModule(&MacroNode{
+			Name:     "input",
+			Args:     []string{"name", "type", "size"},
+			Defaults: map[string]Expr{"type": NewStringExpr("text", noPos), "size": NewNumberExpr("20", noPos)},
+			Body:     NewBodyNode(noPos, NewPrintNode(NewNameExpr("type", noPos), noPos)),
+		}),
+	),
 	newParseTest(
 		"import statement",
 		"{% import '::macros.html.twig' as mac %}",
@@ -367,6 +482,15 @@ var parseTests = []parseTest{
 		"{% verbatim %}{{as is}}{% endverbatim %}",
 		mkModule(NewTextNode("{{as is}}", noPos)),
 	),
+	newParseTest(
+		"sandbox tag",
+		"{% sandbox %}\n\t{% include 'user.twig' %}\n{% endsandbox %}",
+		mkModule(NewSandboxNode(NewBodyNode(noPos,
+			NewTextNode("\n\t", noPos),
+			NewIncludeNode(NewStringExpr("user.twig", noPos), nil, false, noPos),
+			NewTextNode("\n", noPos)), noPos)),
+	),
+	newErrorTest("sandbox with other content", "{% sandbox %}{{ a }}{% endsandbox %}", `only "include" tags are allowed within a "sandbox" tag on line 1, column 13`),
 }
 
 func nodeEqual(a, b Node) bool {
@@ -400,3 +524,46 @@ func TestParse(t *testing.T) {
 		evaluateTest(t, test)
 	}
 }
+
+func TestWhitespaceControl(t *testing.T) {
+	tree, err := Parse("{%- if a ~%}{{~ b -}}{#- c #}{% endif -%}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := tree.Root().All()
+	if len(nodes) != 1 {
+		t.Fatalf("expected one node, got %v", nodes)
+	}
+	n, ok := nodes[0].(*IfNode)
+	if !ok {
+		t.Fatalf("expected IfNode, got %v", nodes[0])
+	}
+	if expected := (TrimmableNode{TrimBefore: true, TrimAfter: true}); n.TrimmableNode != expected {
+		t.Errorf("if: got %+v, expected %+v", n.TrimmableNode, expected)
+	}
+	body := n.Body.(*BodyNode).All()
+	if len(body) != 2 {
+		t.Fatalf("expected two nodes in body, got %v", body)
+	}
+	if expected := (TrimmableNode{TrimLineBefore: true, TrimAfter: true}); body[0].(*PrintNode).TrimmableNode != expected {
+		t.Errorf("print: got %+v, expected %+v", body[0].(*PrintNode).TrimmableNode, expected)
+	}
+	if expected := (TrimmableNode{TrimBefore: true}); body[1].(*CommentNode).TrimmableNode != expected {
+		t.Errorf("comment: got %+v, expected %+v", body[1].(*CommentNode).TrimmableNode, expected)
+	}
+}
+
+func TestTreeUses(t *testing.T) {
+	tree, err := Parse("{% if a %}{{ a|upper }}{% elseif b %}{% filter lower|upper %}{{ b }}{% endfilter %}{% endif %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := tree.Tags()
+	if len(tags) != 2 || tags["if"] != (Pos{1, 3}) || tags["filter"] != (Pos{1, 40}) {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	filters := tree.Filters()
+	if len(filters) != 2 || filters["upper"] != (Pos{1, 14}) || filters["lower"] != (Pos{1, 47}) {
+		t.Errorf("unexpected filters %v", filters)
+	}
+}
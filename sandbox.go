@@ -0,0 +1,127 @@
+package stick
+
+import (
+	"fmt"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// A SecurityPolicy defines what a sandboxed template is allowed to do.
+//
+// Tags and filters are checked when a template is loaded, functions, methods
+// and fields are checked when they are used. Elements of maps, slices and arrays
+// are always allowed. A nil SecurityPolicy allows nothing.
+type SecurityPolicy struct {
+	Tags      []string            // Allowed tags, such as "if" or "for".
+	Filters   []string            // Allowed filters.
+	Functions []string            // Allowed functions.
+	Methods   map[string][]string // Allowed methods, by type name such as "main.User".
+	Fields    map[string][]string // Allowed struct fields, by type name such as "main.User".
+}
+
+func contains(list []string, name string) bool {
+	for _, v := range list {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTree returns a SecurityError if the given tree uses a tag or filter
+// that is not allowed. The violation appearing first in the template is reported.
+func (p *SecurityPolicy) checkTree(name string, tree *parse.Tree) error {
+	var err *SecurityError
+	check := func(kind string, used map[string]parse.Pos, allowed []string) {
+		for v, pos := range used {
+			if contains(allowed, v) {
+				continue
+			}
+			if err == nil || pos.Line < err.Pos.Line || (pos.Line == err.Pos.Line && pos.Offset < err.Pos.Offset) {
+				err = &SecurityError{Name: name, Pos: pos, Kind: kind, Subject: v}
+			}
+		}
+	}
+	var tags, filters []string
+	if p != nil {
+		tags, filters = p.Tags, p.Filters
+	}
+	check("tag", tree.Tags(), tags)
+	check("filter", tree.Filters(), filters)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkFunction returns a SecurityError if the named function is not allowed.
+func (p *SecurityPolicy) checkFunction(name string) error {
+	if p != nil && contains(p.Functions, name) {
+		return nil
+	}
+	return &SecurityError{Kind: "function", Subject: name}
+}
+
+// checkAttr returns a SecurityError if the named method or field of the given
+// type is not allowed.
+func (p *SecurityPolicy) checkAttr(typ string, name string, method bool) error {
+	kind := "field"
+	var allowed map[string][]string
+	if p != nil {
+		allowed = p.Fields
+	}
+	if method {
+		kind = "method"
+		if p != nil {
+			allowed = p.Methods
+		}
+	}
+	if contains(allowed[typ], name) {
+		return nil
+	}
+	return &SecurityError{Kind: kind, Subject: name, Type: typ}
+}
+
+// A SecurityError is returned when a sandboxed template does something that
+// is not allowed by the SecurityPolicy.
+type SecurityError struct {
+	Name    string    // The name of the template.
+	Pos     parse.Pos // The position of the violation.
+	Kind    string    // What is not allowed: "tag", "filter", "function", "method" or "field".
+	Subject string    // The name of the tag, filter, function, method or field.
+	Type    string    // The name of the type, for methods and fields.
+}
+
+func (e *SecurityError) Error() string {
+	what := fmt.Sprintf(`%s "%s"`, e.Kind, e.Subject)
+	if e.Type != "" {
+		what += fmt.Sprintf(` on "%s"`, e.Type)
+	}
+	return fmt.Sprintf("sandbox: %s is not allowed on line %d, column %d in %s", what, e.Pos.Line, e.Pos.Offset, e.Name)
+}
+
+// SandboxExtension restricts what templates are allowed to do.
+//
+// By default, only templates included within a sandbox tag are sandboxed:
+//
+//	{% sandbox %}
+//		{% include 'user.html.twig' %}
+//	{% endsandbox %}
+//
+// If Global is true, every template is sandboxed.
+type SandboxExtension struct {
+	Policy *SecurityPolicy
+	Global bool
+}
+
+// NewSandboxExtension returns a SandboxExtension using the given policy.
+func NewSandboxExtension(policy *SecurityPolicy, global bool) *SandboxExtension {
+	return &SandboxExtension{policy, global}
+}
+
+// Init configures the sandbox on the given Env.
+func (e *SandboxExtension) Init(env *Env) error {
+	env.Policy = e.Policy
+	env.Sandboxed = e.Global
+	return nil
+}
@@ -0,0 +1,109 @@
+package stick
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type sandboxUser struct {
+	Name     string
+	Password string
+}
+
+func (u sandboxUser) Greeting() string {
+	return "Hello, " + u.Name
+}
+
+func (u sandboxUser) Secret() string {
+	return u.Password
+}
+
+func TestSandbox(t *testing.T) {
+	policy := &SecurityPolicy{
+		Tags:      []string{"if", "for"},
+		Filters:   []string{"upper"},
+		Functions: []string{"allowed"},
+		Methods:   map[string][]string{"stick.sandboxUser": {"Greeting"}},
+		Fields:    map[string][]string{"stick.sandboxUser": {"Name"}},
+	}
+	tests := []struct {
+		name     string
+		tpl      string
+		expected string
+		err      string
+	}{
+		{"allowed", `{% if user.Name %}{{ user.Name|upper }}, {{ user.Greeting }}, {{ allowed() }}{{ items.a }}{% endif %}`, "TYLER, Hello, Tyler, ok1", ""},
+		{"tag", "{{ 1 }}\n{% set a = 1 %}{% macro m() %}{% endmacro %}", "", `sandbox: tag "set" is not allowed on line 2, column 3 in tag`},
+		{"filter", `{% for i in 1..2 %}{{ i|lower }}{% endfor %}`, "", `sandbox: filter "lower" is not allowed on line 1, column 23 in filter`},
+		{"function", `{{ denied() }}`, "", `sandbox: function "denied" is not allowed on line 1, column 3 in function`},
+		{"method", `{{ user.Secret }}`, "", `sandbox: method "Secret" on "stick.sandboxUser" is not allowed on line 1, column 7 in method`},
+		{"field", `{{ user.Password }}`, "", `sandbox: field "Password" on "stick.sandboxUser" is not allowed on line 1, column 7 in field`},
+	}
+	templates := make(map[string]string)
+	for _, test := range tests {
+		templates[test.name] = test.tpl
+	}
+
+	newEnv := func(global bool) *Env {
+		env := New(&MemoryLoader{templates})
+		env.Functions["allowed"] = func(ctx Context, args ...Value) Value { return "ok" }
+		env.Functions["denied"] = func(ctx Context, args ...Value) Value { return "" }
+		env.Filters["upper"] = func(ctx Context, val Value, args ...Value) Value { return strings.ToUpper(CoerceString(val)) }
+		env.Filters["lower"] = func(ctx Context, val Value, args ...Value) Value { return strings.ToLower(CoerceString(val)) }
+		if err := env.Register(NewSandboxExtension(policy, global)); err != nil {
+			t.Fatal(err)
+		}
+		return env
+	}
+	ctx := map[string]Value{
+		"user":  sandboxUser{"Tyler", "hunter2"},
+		"items": map[string]Value{"a": 1},
+	}
+
+	env := newEnv(true)
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		err := env.Execute(test.name, buf, ctx)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			} else if buf.String() != test.expected {
+				t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, buf.String())
+			}
+			continue
+		}
+		if _, ok := cause(err).(*SecurityError); !ok {
+			t.Errorf("%s: expected SecurityError, got %#v", test.name, err)
+		} else if err.Error() != test.err {
+			t.Errorf("%s: expected error %#v, got %#v", test.name, test.err, err.Error())
+		}
+		if buf.String() != "" {
+			t.Errorf("%s: expected no output, got %#v", test.name, buf.String())
+		}
+	}
+
+	if _, err := env.Load("tag"); err == nil {
+		t.Errorf("expected error loading template with disallowed tag")
+	}
+
+	// Templates are not sandboxed unless included within a sandbox tag.
+	env = newEnv(false)
+	templates["main"] = `{% set x = user.Secret %}{{ x }} {% sandbox %}{% include 'method' %}{% endsandbox %}`
+	buf := &bytes.Buffer{}
+	err := env.Execute("main", buf, ctx)
+	if _, ok := cause(err).(*SecurityError); !ok || !strings.Contains(err.Error(), `in method, from include on line 1, column 49 in main`) {
+		t.Errorf("expected SecurityError from sandboxed include, got %#v", err)
+	}
+	if buf.String() != "hunter2 " {
+		t.Errorf("unexpected output %#v", buf.String())
+	}
+
+	templates["main"] = `{% sandbox %}{% include 'allowed' %}{% endsandbox %} {{ user.Secret }}`
+	buf.Reset()
+	if err := env.Execute("main", buf, ctx); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	} else if buf.String() != "TYLER, Hello, Tyler, ok1 hunter2" {
+		t.Errorf("unexpected output %#v", buf.String())
+	}
+}
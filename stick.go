@@ -2,7 +2,9 @@ package stick // import "github.com/tystuyfzand/stick"
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"time"
 
 	"github.com/tystuyfzand/stick/parse"
 )
@@ -29,6 +31,9 @@ type Filter func(ctx Context, val Value, args ...Value) Value
 // A Test represents a user-defined test.
 // Tests are used to make some comparisons more expressive. Tests
 // also accept arguments and can consist of two words.
+//
+// A test named "defined" receives an Undefined in place of an undefined
+// variable or attribute, rather than an error or null.
 type Test func(ctx Context, val Value, args ...Value) bool
 
 // Env represents a configured Stick environment.
@@ -39,6 +44,49 @@ type Env struct {
 	Tests     map[string]Test            // User-defined tests.
 	Visitors  []parse.NodeVisitor        // User-defined node visitors.
 	Parsers   map[string]parse.TagParser // User-defined tag parsers.
+	Cache     TemplateCache              // Parsed template cache, or nil to disable caching.
+	Policy    *SecurityPolicy            // Security policy for sandboxed templates.
+	Sandboxed bool                       // If true, all templates are sandboxed.
+	Limits    Limits                     // Resource limits for executing templates.
+
+	// FunctionParams and FilterParams contain the parameter names of
+	// user-defined functions and filters, in order. They are used to map
+	// named arguments, such as "pretty=true", to positions. Filter parameters
+	// do not include the value being filtered.
+	FunctionParams map[string][]string
+	FilterParams   map[string][]string
+
+	// FragmentCache stores the rendered output of cache tags. If nil, the
+	// body of each cache tag is executed every time.
+	FragmentCache FragmentCache
+
+	// Constants contains named values for the constant test and function.
+	Constants map[string]Value
+
+	// Timezone is the default timezone for dates, such as those formatted
+	// by the date filter. If nil, dates keep their own timezone and date
+	// strings are parsed in the local timezone.
+	Timezone *time.Location
+
+	// StrictVariables causes undefined variables and attributes to return an
+	// error, rather than being treated as null.
+	StrictVariables bool
+
+	// UndefinedHandler, if set, is called for each undefined variable, attribute
+	// or macro.
+	UndefinedHandler UndefinedHandler
+
+	// DeprecationHandler, if set, is called each time a template uses a
+	// deprecated feature.
+	DeprecationHandler DeprecationHandler
+
+	// DeprecatedFunctions, DeprecatedFilters and DeprecatedTests mark
+	// user-defined functions, filters and tests as deprecated, by name. Each
+	// use is reported to the DeprecationHandler, along with the message, if
+	// it is not empty, such as "use the foo filter instead".
+	DeprecatedFunctions map[string]string
+	DeprecatedFilters   map[string]string
+	DeprecatedTests     map[string]string
 }
 
 // An Extension is used to group related functions, filters, visitors, etc.
@@ -78,6 +126,27 @@ type Context interface {
 	Scope() ContextScope   // All defined root-level names.
 	Env() *Env
 
+	// Context returns the context.Context of the execution. Long-running
+	// functions and filters should stop when it is done.
+	Context() context.Context
+
+	// Call calls an arrow function passed to a function or filter, such as
+	// the "i => i.name" in "items|map(i => i.name)".
+	Call(fn Value, args ...Value) (Value, error)
+
+	// Include executes the named template, or a *CompiledTemplate, with the
+	// given variables and returns its output.
+	Include(tpl Value, vars map[string]Value) (string, error)
+
+	// Fail reports an error from a function or filter. Execution stops with
+	// err once the function or filter returns.
+	Fail(err error)
+
+	// GetAttr returns the attribute attr of v, as the "." operator does. In a
+	// sandboxed template, methods and fields are checked against the Env's
+	// SecurityPolicy.
+	GetAttr(v Value, attr Value, args ...Value) (Value, error)
+
 	noexport() // Prevent other packages from satisfying this interface.
 }
 
@@ -94,6 +163,14 @@ func New(loader Loader) *Env {
 		Tests:     make(map[string]Test),
 		Visitors:  make([]parse.NodeVisitor, 0),
 		Parsers:   make(map[string]parse.TagParser),
+
+		FunctionParams: make(map[string][]string),
+		FilterParams:   make(map[string][]string),
+		Constants:      make(map[string]Value),
+
+		DeprecatedFunctions: make(map[string]string),
+		DeprecatedFilters:   make(map[string]string),
+		DeprecatedTests:     make(map[string]string),
 	}
 }
 
@@ -104,7 +181,29 @@ func (env *Env) Register(e Extension) error {
 
 // Execute parses and executes the given template.
 func (env *Env) Execute(tpl string, out io.Writer, ctx map[string]Value) error {
-	return execute(tpl, out, ctx, env)
+	return execute(context.Background(), tpl, out, ctx, env)
+}
+
+// ExecuteContext parses and executes the given template, stopping early if c is done.
+//
+// Cancellation is checked before each iteration of a for loop, each macro call,
+// and each included or embedded template. When execution is stopped, the returned
+// error wraps a *CancelError, which wraps c.Err(). The context.Context is available
+// to functions and filters through Context.Context.
+func (env *Env) ExecuteContext(c context.Context, tpl string, out io.Writer, vars map[string]Value) error {
+	return execute(c, tpl, out, vars, env)
+}
+
+// ExecuteBlock parses the given template and executes only the named block.
+//
+// Parent templates are resolved the same way as Execute, so the output matches
+// the block's output when executing the whole template.
+func (env *Env) ExecuteBlock(tpl string, block string, out io.Writer, ctx map[string]Value) error {
+	tree, err := env.load(tpl)
+	if err != nil {
+		return err
+	}
+	return executeBlock(context.Background(), tpl, tree, block, out, ctx, env)
 }
 
 // ExecuteSafe executes the template but does not output anything if an error occurs.
@@ -118,6 +217,8 @@ func (env *Env) ExecuteSafe(tpl string, out io.Writer, ctx map[string]Value) err
 }
 
 // Parse loads and parses the given template.
+//
+// If the Env has a Cache configured, the returned Tree may be shared and must not be modified.
 func (env *Env) Parse(name string) (*parse.Tree, error) {
 	return env.load(name)
 }
@@ -0,0 +1,96 @@
+package stick
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/tystuyfzand/stick/parse"
+)
+
+// A CompiledTemplate is a parsed template, ready to be executed any number of times.
+//
+// A CompiledTemplate is safe for concurrent use by multiple goroutines.
+type CompiledTemplate struct {
+	name string
+	tree *parse.Tree
+	env  *Env
+}
+
+// Load loads and parses the given template, returning a CompiledTemplate.
+//
+// Loading templates ahead of time allows syntax errors to be reported before
+// the template is executed.
+func (env *Env) Load(name string) (*CompiledTemplate, error) {
+	tree, err := env.load(name)
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledTemplate{name, tree, env}, nil
+}
+
+// LoadString parses the given template source, returning a CompiledTemplate.
+// The source is used as the name of the template. The parsed template is not
+// stored in the Env's Cache.
+func (env *Env) LoadString(src string) (*CompiledTemplate, error) {
+	tree, err := env.parse(&StringLoader{}, src)
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledTemplate{src, tree, env}, nil
+}
+
+// Name returns the name of the template.
+func (t *CompiledTemplate) Name() string {
+	return t.name
+}
+
+// Tree returns the parsed template. The returned Tree must not be modified.
+func (t *CompiledTemplate) Tree() *parse.Tree {
+	return t.tree
+}
+
+// Blocks returns the blocks defined in the template. The returned map must not be modified.
+func (t *CompiledTemplate) Blocks() map[string]*parse.BlockNode {
+	return t.tree.Blocks()
+}
+
+// Macros returns the macros defined in the template. The returned map must not be modified.
+func (t *CompiledTemplate) Macros() map[string]*parse.MacroNode {
+	return t.tree.Macros()
+}
+
+// Execute executes the template, writing the output to out.
+func (t *CompiledTemplate) Execute(out io.Writer, ctx map[string]Value) error {
+	return executeTree(context.Background(), t.name, t.tree, out, ctx, t.env)
+}
+
+// ExecuteContext executes the template, stopping early if c is done.
+// See Env.ExecuteContext for details.
+func (t *CompiledTemplate) ExecuteContext(c context.Context, out io.Writer, ctx map[string]Value) error {
+	return executeTree(c, t.name, t.tree, out, ctx, t.env)
+}
+
+// RenderBlock executes only the named block, writing the output to out.
+//
+// Parent templates are resolved as usual, so the block may be defined in,
+// or call parent() to render, blocks from a parent template or from templates
+// included with "use".
+func (t *CompiledTemplate) RenderBlock(name string, out io.Writer, ctx map[string]Value) error {
+	return executeBlock(context.Background(), t.name, t.tree, name, out, ctx, t.env)
+}
+
+// CallMacro calls the named macro with the given arguments, returning its output.
+func (t *CompiledTemplate) CallMacro(name string, args ...Value) (Value, error) {
+	macros := t.tree.Macros()
+	macro, ok := macros[name]
+	if !ok {
+		return nil, errors.New("undefined macro " + name)
+	}
+	s := NewState(t.name, ioutil.Discard, nil, t.env)
+	for k, v := range macros {
+		s.localMacros[k] = v
+	}
+	return s.callMacro(macro.Start(), macroDef{macro}, args...)
+}
@@ -0,0 +1,132 @@
+package stick
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompiledTemplate(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"page.twig": `{% macro wrap(v) %}[{{ _self.inner(v) }}]{% endmacro %}` +
+			`{% macro inner(v) %}{{ v }}{% endmacro %}` +
+			`<h1>{% block title %}{{ title }}{% endblock %}</h1>{% block content %}Hello, {{ name }}!{% endblock %}`,
+		"broken.twig": `{% if %}`,
+	}})
+
+	if _, err := env.Load("broken.twig"); err == nil {
+		t.Errorf("expected syntax error when loading template")
+	}
+
+	tpl, err := env.Load("page.twig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tpl.Name() != "page.twig" {
+		t.Errorf("unexpected name %s", tpl.Name())
+	}
+	if l := len(tpl.Blocks()); l != 2 {
+		t.Errorf("expected 2 blocks, got %d", l)
+	}
+	if l := len(tpl.Macros()); l != 2 {
+		t.Errorf("expected 2 macros, got %d", l)
+	}
+
+	for _, name := range []string{"World", "Tyler"} {
+		buf := &bytes.Buffer{}
+		if err := tpl.Execute(buf, map[string]Value{"title": "Title", "name": name}); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "<h1>Title</h1>Hello, " + name + "!"; buf.String() != expected {
+			t.Errorf("expected %#v, got %#v", expected, buf.String())
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tpl.RenderBlock("content", buf, map[string]Value{"name": "World"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Hello, World!"; buf.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, buf.String())
+	}
+	if err := tpl.RenderBlock("missing", buf, nil); err == nil {
+		t.Errorf("expected error rendering missing block")
+	}
+
+	res, err := tpl.CallMacro("wrap", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CoerceString(res) != "[value]" {
+		t.Errorf("expected %#v, got %#v", "[value]", res)
+	}
+	if _, err := tpl.CallMacro("missing"); err == nil {
+		t.Errorf("expected error calling missing macro")
+	}
+}
+
+func TestExecuteBlock(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"base.twig": `{% import 'macros.twig' as m %}{% set sep = ' | ' %}` +
+			`<title>{% block title %}Site{% endblock %}</title>{% block body %}{% endblock %}`,
+		"layout.twig": `{% extends 'base.twig' %}{% use 'nav.twig' %}` +
+			`{% block title %}{{ parent() }}{{ sep }}Layout{% endblock %}`,
+		"nav.twig":    `{% block nav %}<nav>{{ m.link(title) }}</nav>{% endblock %}`,
+		"macros.twig": `{% macro link(v) %}<a>{{ v }}</a>{% endmacro %}`,
+		"page.twig": `{% extends 'layout.twig' %}` +
+			`{% block title %}{{ parent() }}{{ sep }}{{ title }}{% endblock %}` +
+			`{% block body %}{{ block('nav') }}{% endblock %}`,
+	}})
+
+	tests := []struct {
+		block    string
+		expected string
+	}{
+		{"title", "Site | Layout | Page"},
+		{"body", "<nav><a>Page</a></nav>"},
+		{"nav", "<nav><a>Page</a></nav>"},
+	}
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		err := env.ExecuteBlock("page.twig", test.block, buf, map[string]Value{"title": "Page"})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.block, err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%s: expected %#v, got %#v", test.block, test.expected, buf.String())
+		}
+	}
+
+	if err := env.ExecuteBlock("page.twig", "missing", &bytes.Buffer{}, nil); err == nil {
+		t.Errorf("expected error rendering missing block")
+	}
+}
+
+func TestLoadString(t *testing.T) {
+	env := New(&MemoryLoader{map[string]string{
+		"page.twig": `{% include tpl with {name: 'Include'} %}|{{ render(tpl, {name: 'Func'}) }}`,
+	}})
+	env.Functions["render"] = func(ctx Context, args ...Value) Value {
+		out, err := ctx.Include(args[0], args[1].(map[string]Value))
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		return out
+	}
+
+	if _, err := env.LoadString(`{% if %}`); err == nil {
+		t.Errorf("expected syntax error when loading template")
+	}
+
+	tpl, err := env.LoadString(`Hello, {{ name }}!`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := env.Execute("page.twig", buf, map[string]Value{"tpl": tpl}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Hello, Include!|Hello, Func!"; buf.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, buf.String())
+	}
+}
@@ -2,6 +2,7 @@ package twig
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/tystuyfzand/stick"
 	"github.com/tystuyfzand/stick/parse"
@@ -40,6 +41,10 @@ func (e *AutoEscapeExtension) Init(env *stick.Env) error {
 
 		return stick.NewSafeValue(escfn(stick.CoerceString(val)), ct)
 	}
+	if env.FilterParams == nil {
+		env.FilterParams = make(map[string][]string)
+	}
+	env.FilterParams["escape"] = []string{"strategy", "charset"}
 	return nil
 }
 
@@ -53,13 +58,21 @@ func NewAutoEscapeExtension() *AutoEscapeExtension {
 			"js":        escape.JS,
 			"css":       escape.CSS,
 			"url":       escape.URLQueryParam,
+			"sql":       escape.SQL,
 		},
 	}
 }
 
 // AutoEscapeVisitor can be used to automatically apply the "escape" filter
 // to any PrintNode.
+//
+// The escaping strategy is guessed from the template name, and can be
+// overridden within a template using the autoescape tag.
+//
+// A single visitor is shared by every template parsed by an Env, so only
+// one template is visited at a time.
 type autoEscapeVisitor struct {
+	mu    sync.Mutex
 	stack []string
 }
 
@@ -86,11 +99,20 @@ func (v *autoEscapeVisitor) current() string {
 func (v *autoEscapeVisitor) Enter(n parse.Node) {
 	switch node := n.(type) {
 	case *parse.ModuleNode:
+		v.mu.Lock()
 		v.push(v.guessTypeFromName(node.Origin))
 	case *parse.BlockNode:
-		v.push(v.guessTypeFromName(node.Origin))
+		// Blocks are defined in the same template as the enclosing module,
+		// so they inherit the strategy of any enclosing autoescape tag.
+		v.push(v.current())
+	case *parse.AutoEscapeNode:
+		v.push(node.Strategy)
 	case *parse.PrintNode:
 		ct := v.current()
+		if ct == "" {
+			// Escaping is disabled.
+			return
+		}
 		v := node.X
 		r := parse.NewFilterExpr(
 			"escape",
@@ -103,7 +125,10 @@ func (v *autoEscapeVisitor) Enter(n parse.Node) {
 
 func (v *autoEscapeVisitor) Leave(n parse.Node) {
 	switch n.(type) {
-	case *parse.ModuleNode, *parse.BlockNode:
+	case *parse.ModuleNode:
+		v.pop()
+		v.mu.Unlock()
+	case *parse.BlockNode, *parse.AutoEscapeNode:
 		v.pop()
 	}
 }
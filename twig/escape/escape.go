@@ -109,3 +109,29 @@ func URLQueryParam(in string) string {
 	}
 	return out.String()
 }
+
+// SQL provides an escaper for string literals in SQL statements, in the same
+// way as MySQL's mysql_real_escape_string. Prefer parameterized queries where
+// possible.
+func SQL(in string) string {
+	var out = &bytes.Buffer{}
+	for _, c := range in {
+		switch c {
+		case 0:
+			out.WriteString("\\0")
+		case '\n':
+			out.WriteString("\\n")
+		case '\r':
+			out.WriteString("\\r")
+		case 26:
+			// Ctrl-Z
+			out.WriteString("\\Z")
+		case '\\', '\'', '"':
+			out.WriteByte('\\')
+			out.WriteRune(c)
+		default:
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
@@ -40,3 +40,10 @@ func ExampleURLQueryParam() {
 	// Output:
 	// ?who=%D7%9E%D7%99%D7%99%D7%9F%20%D7%9E%D7%90%D7%9E%D7%A2%D7%9D
 }
+
+func ExampleSQL() {
+	input := "O'Reilly \"quoted\"\n"
+	fmt.Printf("SELECT * FROM books WHERE author = '%s'", escape.SQL(input))
+	// Output:
+	// SELECT * FROM books WHERE author = 'O\'Reilly \"quoted\"\n'
+}
@@ -3,6 +3,7 @@ package twig_test
 import (
 	"bytes"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/tystuyfzand/stick"
@@ -95,3 +96,60 @@ func TestAutoEscapeExtension(t *testing.T) {
 		t.Errorf("expected output to be escaped, but got: %s", actual)
 	}
 }
+
+func TestAutoEscapeTag(t *testing.T) {
+	env := twig.New(&stick.MemoryLoader{Templates: map[string]string{
+		"page.html.twig": `<p>{{ message }}</p>` +
+			`<script>var m = "{% autoescape 'js' %}{{ message }}{% endautoescape %}";</script>` +
+			`{% autoescape false %}{{ message }}{% block content %}{{ message }}{% endblock %}{% endautoescape %}` +
+			`{% autoescape %}{{ message }}{% endautoescape %}` +
+			`{{ '<b> </b>'|spaceless }}`,
+	}})
+	buf := &bytes.Buffer{}
+	if err := env.Execute("page.html.twig", buf, map[string]stick.Value{"message": "<'>"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `<p>&lt;&#39;&gt;</p><script>var m = "\u003C\u0027\u003E";</script><'><'>&lt;&#39;&gt;<b></b>`
+	if res := buf.String(); res != expected {
+		t.Errorf("expected %s, got %s", expected, res)
+	}
+}
+
+func TestEscapeSQL(t *testing.T) {
+	env := twig.New(nil)
+	buf := &bytes.Buffer{}
+	err := env.Execute(`{% autoescape 'sql' %}SELECT * FROM users WHERE name = '{{ name }}'{% endautoescape %}`, buf, map[string]stick.Value{"name": `x' OR '1'='1`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := `SELECT * FROM users WHERE name = 'x\' OR \'1\'=\'1'`; buf.String() != expected {
+		t.Errorf("expected %s, got %s", expected, buf.String())
+	}
+}
+
+func TestAutoEscapeConcurrentParse(t *testing.T) {
+	env := twig.New(&stick.MemoryLoader{Templates: map[string]string{
+		"page.html.twig": `<p>{{ message }}</p>`,
+		"page.js.twig":   `var m = "{{ message }}";`,
+	}})
+	expected := map[string]string{
+		"page.html.twig": `<p>&lt;&#39;&gt;</p>`,
+		"page.js.twig":   `var m = "\u003C\u0027\u003E";`,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for name, exp := range expected {
+			wg.Add(1)
+			go func(name, exp string) {
+				defer wg.Done()
+				buf := &bytes.Buffer{}
+				if err := env.Execute(name, buf, map[string]stick.Value{"message": "<'>"}); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if res := buf.String(); res != exp {
+					t.Errorf("%s: expected %s, got %s", name, exp, res)
+				}
+			}(name, exp)
+		}
+	}
+	wg.Wait()
+}
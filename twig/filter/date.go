@@ -0,0 +1,703 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tystuyfzand/stick"
+)
+
+// filterDate takes two optional arguments, a PHP date format and a timezone.
+// The format defaults to "F j, Y H:i". Value val may be a time.Time, null or
+// "now" for the current time, a Unix timestamp, or a date string.
+//
+// The timezone may be the name of a location, such as "Europe/Paris", or
+// false to keep the timezone of val. If it is not given, the Env's Timezone
+// is used, if any. Date strings are parsed in the Env's Timezone and then
+// converted to the timezone.
+func filterDate(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	format := "F j, Y H:i"
+	if len(args) > 0 && args[0] != nil {
+		format = stick.CoerceString(args[0])
+	}
+	var tz stick.Value
+	if len(args) > 1 {
+		tz = args[1]
+	}
+	loc, ok := dateLocation(ctx, tz)
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	dt, ok := ToTime(val, defaultLocation(ctx))
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	if loc != nil {
+		dt = dt.In(loc)
+	}
+	return formatDate(dt, format)
+}
+
+// dateStyles are the ICU patterns of the English date formats.
+var dateStyles = map[string]string{
+	"none":   "",
+	"short":  "M/d/yy",
+	"medium": "MMM d, y",
+	"long":   "MMMM d, y",
+	"full":   "EEEE, MMMM d, y",
+}
+
+// timeStyles are the ICU patterns of the English time formats.
+var timeStyles = map[string]string{
+	"none":   "",
+	"short":  "h:mm a",
+	"medium": "h:mm:ss a",
+	"long":   "h:mm:ss a z",
+	"full":   "h:mm:ss a zzzz",
+}
+
+// filterFormatDateTime takes the optional arguments dateFormat and
+// timeFormat, each one of "none", "short", "medium" (the default), "long" or
+// "full", an ICU pattern that overrides them, and a timezone, as for the date
+// filter.
+//
+// For compatibility with Twig, the calendar and locale arguments follow, but
+// only the gregorian calendar and English are supported.
+func filterFormatDateTime(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	dateFormat, timeFormat := "medium", "medium"
+	if len(args) > 0 && args[0] != nil {
+		dateFormat = stick.CoerceString(args[0])
+	}
+	if len(args) > 1 && args[1] != nil {
+		timeFormat = stick.CoerceString(args[1])
+	}
+	pattern := ""
+	if len(args) > 2 && args[2] != nil {
+		pattern = stick.CoerceString(args[2])
+	}
+	var tz stick.Value
+	if len(args) > 3 {
+		tz = args[3]
+	}
+	return formatDateTime(ctx, val, dateFormat, timeFormat, pattern, tz)
+}
+
+// filterFormatDate works like format_datetime, without the timeFormat
+// argument.
+func filterFormatDate(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	return filterFormatDateTime(ctx, val, insertArg(args, 1, "none")...)
+}
+
+// filterFormatTime works like format_datetime, without the dateFormat
+// argument.
+func filterFormatTime(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	return filterFormatDateTime(ctx, val, insertArg(args, 0, "none")...)
+}
+
+// insertArg returns args with v inserted at position i. Missing arguments
+// before i are nil.
+func insertArg(args []stick.Value, i int, v stick.Value) []stick.Value {
+	res := make([]stick.Value, 0, len(args)+1)
+	for j := 0; j < i; j++ {
+		if j < len(args) {
+			res = append(res, args[j])
+		} else {
+			res = append(res, nil)
+		}
+	}
+	res = append(res, v)
+	if i < len(args) {
+		res = append(res, args[i:]...)
+	}
+	return res
+}
+
+// formatDateTime formats val using the given date and time styles, or the
+// ICU pattern, if it is not empty, in the timezone tz.
+func formatDateTime(ctx stick.Context, val stick.Value, dateFormat, timeFormat, pattern string, tz stick.Value) stick.Value {
+	loc, ok := dateLocation(ctx, tz)
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	dt, ok := ToTime(val, defaultLocation(ctx))
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	if loc != nil {
+		dt = dt.In(loc)
+	}
+	if pattern == "" {
+		d, dok := dateStyles[dateFormat]
+		t, tok := timeStyles[timeFormat]
+		if !dok || !tok {
+			// TODO: Report error
+			return nil
+		}
+		switch {
+		case d == "":
+			pattern = t
+		case t == "":
+			pattern = d
+		case dateFormat == "long" || dateFormat == "full":
+			pattern = d + " 'at' " + t
+		default:
+			pattern = d + ", " + t
+		}
+	}
+	return formatICU(dt, pattern)
+}
+
+// formatICU formats dt using an ICU date pattern, such as "MMM d, y". Text
+// within single quotes is literal, and two single quotes are a quote.
+func formatICU(dt time.Time, pattern string) string {
+	res := ""
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if c == '\'' {
+			if i+1 < len(runes) && runes[i+1] == '\'' {
+				res += "'"
+				i++
+				continue
+			}
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						res += "'"
+						i++
+						continue
+					}
+					break
+				}
+				res += string(runes[i])
+			}
+			continue
+		}
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			res += string(c)
+			continue
+		}
+		n := 1
+		for i+n < len(runes) && runes[i+n] == c {
+			n++
+		}
+		i += n - 1
+		res += formatICUField(dt, c, n)
+	}
+	return res
+}
+
+// formatICUField returns the part of dt represented by the ICU pattern
+// letter c repeated n times. Unsupported letters are omitted.
+func formatICUField(dt time.Time, c rune, n int) string {
+	pad := func(v int) string {
+		return fmt.Sprintf("%0*d", n, v)
+	}
+	switch c {
+	case 'G':
+		if dt.Year() <= 0 {
+			return "BC"
+		}
+		return "AD"
+	case 'y':
+		if n == 2 {
+			return fmt.Sprintf("%02d", dt.Year()%100)
+		}
+		return pad(dt.Year())
+	case 'M', 'L':
+		switch {
+		case n >= 4:
+			return dt.Month().String()
+		case n == 3:
+			return dt.Month().String()[:3]
+		}
+		return pad(int(dt.Month()))
+	case 'd':
+		return pad(dt.Day())
+	case 'D':
+		return pad(dt.YearDay())
+	case 'E':
+		if n >= 4 {
+			return dt.Weekday().String()
+		}
+		return dt.Weekday().String()[:3]
+	case 'a':
+		if dt.Hour() < 12 {
+			return "AM"
+		}
+		return "PM"
+	case 'h':
+		h := dt.Hour() % 12
+		if h == 0 {
+			h = 12
+		}
+		return pad(h)
+	case 'H':
+		return pad(dt.Hour())
+	case 'k':
+		h := dt.Hour()
+		if h == 0 {
+			h = 24
+		}
+		return pad(h)
+	case 'K':
+		return pad(dt.Hour() % 12)
+	case 'm':
+		return pad(dt.Minute())
+	case 's':
+		return pad(dt.Second())
+	case 'S':
+		if n > 9 {
+			n = 9
+		}
+		return fmt.Sprintf("%09d", dt.Nanosecond())[:n]
+	case 'z', 'v', 'V':
+		// Go does not provide the long names of timezones, so the
+		// abbreviation is used for every width.
+		name, _ := dt.Zone()
+		return name
+	case 'Z', 'x', 'X':
+		_, offset := dt.Zone()
+		sign := "+"
+		if offset < 0 {
+			sign, offset = "-", -offset
+		}
+		return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+	}
+	return ""
+}
+
+// dateLocation returns the location for the timezone argument tz of a date
+// filter. It is nil if tz is false, to keep the timezone of the date, and the
+// Env's Timezone if tz is nil.
+func dateLocation(ctx stick.Context, tz stick.Value) (*time.Location, bool) {
+	if tz == nil {
+		return defaultLocation(ctx), true
+	}
+	if b, ok := tz.(bool); ok && !b {
+		return nil, true
+	}
+	loc, err := time.LoadLocation(stick.CoerceString(tz))
+	if err != nil {
+		return nil, false
+	}
+	return loc, true
+}
+
+// defaultLocation returns the Env's Timezone, or nil if it is not set.
+func defaultLocation(ctx stick.Context) *time.Location {
+	if ctx == nil || ctx.Env() == nil {
+		return nil
+	}
+	return ctx.Env().Timezone
+}
+
+// formatDate formats dt using a PHP date format. A backslash escapes the
+// following character.
+func formatDate(dt time.Time, format string) string {
+	res := ""
+	runes := []rune(format)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if c == '\\' && i < len(runes)-1 {
+			i++
+			res += string(runes[i])
+			continue
+		}
+		res += formatDateChar(dt, c)
+	}
+	return res
+}
+
+// formatDateChar returns the part of dt represented by the PHP date format
+// character c, or c itself if it is not a format character.
+func formatDateChar(dt time.Time, c rune) string {
+	switch c {
+	// Day
+	case 'd':
+		return fmt.Sprintf("%02d", dt.Day())
+	case 'D':
+		return dt.Format("Mon")
+	case 'j':
+		return strconv.Itoa(dt.Day())
+	case 'l':
+		return dt.Weekday().String()
+	case 'N':
+		if dt.Weekday() == time.Sunday {
+			return "7"
+		}
+		return strconv.Itoa(int(dt.Weekday()))
+	case 'S':
+		return ordinalSuffix(dt.Day())
+	case 'w':
+		return strconv.Itoa(int(dt.Weekday()))
+	case 'z':
+		return strconv.Itoa(dt.YearDay() - 1)
+
+	// Week
+	case 'W':
+		_, week := dt.ISOWeek()
+		return fmt.Sprintf("%02d", week)
+
+	// Month
+	case 'F':
+		return dt.Month().String()
+	case 'm':
+		return fmt.Sprintf("%02d", int(dt.Month()))
+	case 'M':
+		return dt.Format("Jan")
+	case 'n':
+		return strconv.Itoa(int(dt.Month()))
+	case 't':
+		return strconv.Itoa(daysInMonth(dt))
+
+	// Year
+	case 'L':
+		if isLeapYear(dt.Year()) {
+			return "1"
+		}
+		return "0"
+	case 'o':
+		year, _ := dt.ISOWeek()
+		return strconv.Itoa(year)
+	case 'X':
+		return formatYear(dt.Year(), true)
+	case 'x':
+		return formatYear(dt.Year(), dt.Year() >= 10000)
+	case 'Y':
+		return formatYear(dt.Year(), false)
+	case 'y':
+		return fmt.Sprintf("%02d", dt.Year()%100)
+
+	// Time
+	case 'a':
+		return dt.Format("pm")
+	case 'A':
+		return dt.Format("PM")
+	case 'B':
+		// Swatch Internet time is the number of beats (1/1000th of a day)
+		// since midnight in UTC+1.
+		utc := dt.UTC()
+		secs := (utc.Hour()*3600 + utc.Minute()*60 + utc.Second() + 3600) % 86400
+		return fmt.Sprintf("%03d", int(float64(secs)/86.4))
+	case 'g':
+		return dt.Format("3")
+	case 'G':
+		return strconv.Itoa(dt.Hour())
+	case 'h':
+		return dt.Format("03")
+	case 'H':
+		return fmt.Sprintf("%02d", dt.Hour())
+	case 'i':
+		return fmt.Sprintf("%02d", dt.Minute())
+	case 's':
+		return fmt.Sprintf("%02d", dt.Second())
+	case 'u':
+		return fmt.Sprintf("%06d", dt.Nanosecond()/1000)
+	case 'v':
+		return fmt.Sprintf("%03d", dt.Nanosecond()/1000000)
+
+	// Timezone
+	case 'e':
+		return dt.Location().String()
+	case 'I':
+		if isDST(dt) {
+			return "1"
+		}
+		return "0"
+	case 'O':
+		return dt.Format("-0700")
+	case 'P':
+		return dt.Format("-07:00")
+	case 'p':
+		if _, offset := dt.Zone(); offset == 0 {
+			return "Z"
+		}
+		return dt.Format("-07:00")
+	case 'T':
+		return dt.Format("MST")
+	case 'Z':
+		_, offset := dt.Zone()
+		return strconv.Itoa(offset)
+
+	// Full Date/Time
+	case 'c':
+		return dt.Format("2006-01-02T15:04:05-07:00")
+	case 'r':
+		return dt.Format("Mon, 02 Jan 2006 15:04:05 -0700")
+	case 'U':
+		return strconv.FormatInt(dt.Unix(), 10)
+	}
+	return string(c)
+}
+
+// ordinalSuffix returns the English ordinal suffix for the day of the month.
+func ordinalSuffix(day int) string {
+	if day >= 11 && day <= 13 {
+		return "th"
+	}
+	switch day % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	}
+	return "th"
+}
+
+// daysInMonth returns the number of days in the month of dt.
+func daysInMonth(dt time.Time) int {
+	return time.Date(dt.Year(), dt.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// isLeapYear returns true if year is a leap year.
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// formatYear returns year with at least 4 digits, and a "-" for years BCE.
+// If sign is true, a "+" is added for other years.
+func formatYear(year int, sign bool) string {
+	if year < 0 {
+		return fmt.Sprintf("-%04d", -year)
+	}
+	if sign {
+		return fmt.Sprintf("+%04d", year)
+	}
+	return fmt.Sprintf("%04d", year)
+}
+
+// isDST returns true if dt is in daylight saving time. It is assumed to be
+// if the offset is greater than the smaller of the offsets in January and
+// July.
+func isDST(dt time.Time) bool {
+	_, offset := dt.Zone()
+	_, jan := time.Date(dt.Year(), time.January, 1, 0, 0, 0, 0, dt.Location()).Zone()
+	_, jul := time.Date(dt.Year(), time.July, 1, 0, 0, 0, 0, dt.Location()).Zone()
+	if jan == jul {
+		return false
+	}
+	if jan < jul {
+		return offset > jan
+	}
+	return offset > jul
+}
+
+// filterDateModify takes one argument, a relative date format such as
+// "+1 day", "-2 weeks 3 days", "next month", "last friday", "tomorrow" or
+// "first day of next month", and returns val modified accordingly.
+// Value val may be a time.Time, "now", a Unix timestamp or a date string.
+func filterDateModify(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	if len(args) != 1 {
+		// TODO: Report error
+		return nil
+	}
+	dt, ok := ToTime(val, defaultLocation(ctx))
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	res, ok := modifyTime(dt, stick.CoerceString(args[0]))
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	return res
+}
+
+// dateLayouts are the layouts tried, in order, when parsing a date string.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+	"January 2, 2006",
+	"January 2, 2006 15:04",
+}
+
+// ToTime converts val into a time.Time, as the date filter and function do.
+// Null and "now" are the current time, numbers and numeric strings are Unix
+// timestamps, and other strings are parsed using dateLayouts or as relative
+// formats, such as "+1 day", in loc, or the local timezone if loc is nil.
+func ToTime(val stick.Value, loc *time.Location) (time.Time, bool) {
+	if loc == nil {
+		loc = time.Local
+	}
+	switch v := val.(type) {
+	case nil:
+		return time.Now(), true
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, false
+		}
+		return *v, true
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" || strings.ToLower(v) == "now" {
+			return time.Now(), true
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Unix(int64(n), 0), true
+		}
+		for _, layout := range dateLayouts {
+			if dt, err := time.ParseInLocation(layout, v, loc); err == nil {
+				return dt, true
+			}
+		}
+		// Relative formats, such as "+1 day", are relative to now.
+		return modifyTime(time.Now().In(loc), v)
+	case stick.Number, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return time.Unix(int64(stick.CoerceNumber(v)), 0), true
+	}
+	return time.Time{}, false
+}
+
+// modifyTime applies the relative date format in mod to dt.
+func modifyTime(dt time.Time, mod string) (time.Time, bool) {
+	fields := strings.Fields(strings.ToLower(mod))
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		switch f {
+		case "now":
+			continue
+		case "today", "midnight":
+			dt = startOfDay(dt)
+			continue
+		case "noon":
+			dt = startOfDay(dt).Add(12 * time.Hour)
+			continue
+		case "tomorrow":
+			dt = startOfDay(dt).AddDate(0, 0, 1)
+			continue
+		case "yesterday":
+			dt = startOfDay(dt).AddDate(0, 0, -1)
+			continue
+		case "first", "last":
+			if i+2 < len(fields) && fields[i+1] == "day" && fields[i+2] == "of" {
+				// "first day of" and "last day of" move to the start of the
+				// month before applying the rest of the format, so months of
+				// different lengths do not overflow.
+				start := time.Date(dt.Year(), dt.Month(), 1, dt.Hour(), dt.Minute(), dt.Second(), dt.Nanosecond(), dt.Location())
+				res, ok := modifyTime(start, strings.Join(fields[i+3:], " "))
+				if !ok {
+					return dt, false
+				}
+				if f == "last" {
+					res = res.AddDate(0, 1, -1)
+				}
+				return res, true
+			}
+		}
+
+		var n int
+		switch f {
+		case "next", "+":
+			n = 1
+		case "last", "previous", "-":
+			n = -1
+		case "this":
+			n = 0
+		default:
+			v, err := strconv.Atoi(strings.TrimPrefix(f, "+"))
+			if err != nil {
+				// The number and unit may be written together, as in "+1day".
+				j := strings.IndexFunc(f, func(r rune) bool { return unicode.IsLetter(r) })
+				if j <= 0 {
+					return dt, false
+				}
+				if v, err = strconv.Atoi(strings.TrimPrefix(f[:j], "+")); err != nil {
+					return dt, false
+				}
+				fields[i] = f[j:]
+				i--
+			}
+			n = v
+		}
+		i++
+		if i >= len(fields) {
+			return dt, false
+		}
+		unit := fields[i]
+		if i+1 < len(fields) && fields[i+1] == "ago" {
+			n = -n
+			i++
+		}
+		if wd, ok := weekdays[unit]; ok {
+			dt = moveToWeekday(startOfDay(dt), wd, n)
+			continue
+		}
+		switch strings.TrimSuffix(unit, "s") {
+		case "sec", "second":
+			dt = dt.Add(time.Duration(n) * time.Second)
+		case "min", "minute":
+			dt = dt.Add(time.Duration(n) * time.Minute)
+		case "hour":
+			dt = dt.Add(time.Duration(n) * time.Hour)
+		case "day":
+			dt = dt.AddDate(0, 0, n)
+		case "week":
+			dt = dt.AddDate(0, 0, 7*n)
+		case "fortnight":
+			dt = dt.AddDate(0, 0, 14*n)
+		case "month":
+			dt = dt.AddDate(0, n, 0)
+		case "year":
+			dt = dt.AddDate(n, 0, 0)
+		default:
+			return dt, false
+		}
+	}
+	return dt, true
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// startOfDay returns midnight on the day of dt.
+func startOfDay(dt time.Time) time.Time {
+	return time.Date(dt.Year(), dt.Month(), dt.Day(), 0, 0, 0, 0, dt.Location())
+}
+
+// moveToWeekday moves dt to the nth following weekday wd, or the nth
+// preceding weekday if n is negative. If n is zero, dt is moved to wd on or
+// after dt.
+func moveToWeekday(dt time.Time, wd time.Weekday, n int) time.Time {
+	diff := int(wd - dt.Weekday())
+	switch {
+	case n > 0:
+		if diff <= 0 {
+			diff += 7
+		}
+		return dt.AddDate(0, 0, diff+7*(n-1))
+	case n < 0:
+		if diff >= 0 {
+			diff -= 7
+		}
+		return dt.AddDate(0, 0, diff+7*(n+1))
+	}
+	if diff < 0 {
+		diff += 7
+	}
+	return dt.AddDate(0, 0, diff)
+}
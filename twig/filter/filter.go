@@ -2,48 +2,71 @@
 package filter // import "github.com/tystuyfzand/stick/twig/filter"
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math"
+	"net/http"
 	"net/url"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 
 	"reflect"
-	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/tystuyfzand/stick"
+	"github.com/tystuyfzand/stick/twig/escape"
 )
 
 // builtInFilters returns a map containing all built-in Twig filters,
 // with the exception of "escape", which is provided by the AutoEscapeExtension.
+//
+// The "u" filter is not provided. It returns a PHP object whose lowercase
+// methods, such as u.truncate, cannot be called on a Go value.
 func TwigFilters() map[string]stick.Filter {
 	return map[string]stick.Filter{
 		"abs":              filterAbs,
 		"default":          filterDefault,
 		"batch":            filterBatch,
 		"capitalize":       filterCapitalize,
+		"column":           filterColumn,
 		"convert_encoding": filterConvertEncoding,
+		"data_uri":         filterDataURI,
 		"date":             filterDate,
 		"date_modify":      filterDateModify,
+		"filter":           filterFilter,
+		"find":             filterFind,
 		"first":            filterFirst,
 		"format":           filterFormat,
+		"format_currency":  filterFormatCurrency,
+		"format_date":      filterFormatDate,
+		"format_datetime":  filterFormatDateTime,
+		"format_number":    filterFormatNumber,
+		"format_time":      filterFormatTime,
 		"join":             filterJoin,
 		"json_encode":      filterJSONEncode,
 		"keys":             filterKeys,
 		"last":             filterLast,
 		"length":           filterLength,
 		"lower":            filterLower,
+		"map":              filterMap,
+		"markdown_to_html": filterMarkdownToHTML,
 		"merge":            filterMerge,
 		"nl2br":            filterNL2BR,
 		"number_format":    filterNumberFormat,
 		"raw":              filterRaw,
+		"reduce":           filterReduce,
 		"replace":          filterReplace,
 		"reverse":          filterReverse,
 		"round":            filterRound,
 		"slice":            filterSlice,
 		"sort":             filterSort,
+		"spaceless":        filterSpaceless,
 		"split":            filterSplit,
 		"striptags":        filterStripTags,
 		"title":            filterTitle,
@@ -53,6 +76,40 @@ func TwigFilters() map[string]stick.Filter {
 	}
 }
 
+// TwigFilterParams returns a map containing the parameter names of the
+// built-in Twig filters, for use with named arguments.
+func TwigFilterParams() map[string][]string {
+	return map[string][]string{
+		"batch":            {"size", "fill", "preserve_keys"},
+		"column":           {"name", "index"},
+		"convert_encoding": {"to", "from"},
+		"data_uri":         {"mime", "parameters"},
+		"date":             {"format", "timezone"},
+		"date_modify":      {"modifier"},
+		"default":          {"default"},
+		"filter":           {"arrow"},
+		"find":             {"arrow"},
+		"format_currency":  {"currency", "attrs", "locale"},
+		"format_date":      {"dateFormat", "pattern", "timezone", "calendar", "locale"},
+		"format_datetime":  {"dateFormat", "timeFormat", "pattern", "timezone", "calendar", "locale"},
+		"format_number":    {"attrs", "style", "type", "locale"},
+		"format_time":      {"timeFormat", "pattern", "timezone", "calendar", "locale"},
+		"join":             {"glue", "and"},
+		"json_encode":      {"options"},
+		"map":              {"arrow"},
+		"number_format":    {"decimal", "decimal_point", "thousand_sep"},
+		"reduce":           {"arrow", "initial"},
+		"replace":          {"from"},
+		"reverse":          {"preserve_keys"},
+		"round":            {"precision", "method"},
+		"slice":            {"start", "length", "preserve_keys"},
+		"sort":             {"arrow"},
+		"split":            {"delimiter", "limit"},
+		"striptags":        {"allowable_tags"},
+		"trim":             {"character_mask", "side"},
+	}
+}
+
 // filterAbs takes no arguments and returns the absolute value of val.
 // Value val will be coerced into a number.
 func filterAbs(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
@@ -124,106 +181,149 @@ func filterCapitalize(ctx stick.Context, val stick.Value, args ...stick.Value) s
 	return strings.ToUpper(s[:1]) + s[1:]
 }
 
+// filterConvertEncoding takes two arguments, the encoding to convert val to,
+// and the encoding val is in. The supported encodings are UTF-8, UTF-16,
+// UTF-16BE, UTF-16LE, ISO-8859-1 and US-ASCII. Characters that cannot be
+// represented in the output encoding are replaced with "?".
 func filterConvertEncoding(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+	if len(args) != 2 {
+		// TODO: Report error
+		return nil
+	}
+	to, from := normalizeEncoding(args[0]), normalizeEncoding(args[1])
+	runes, ok := decodeString(stick.CoerceString(val), from)
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	res, ok := encodeRunes(runes, to)
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	return res
 }
 
-func filterDate(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	var requestedLayout string
-	dt, ok := val.(time.Time)
-	if !ok {
-		// if the value is a string of "now" then we can use the current time
-		// @doc https://twig.symfony.com/doc/3.x/filters/date.html
-		valString := stick.CoerceString(val)
-		if strings.ToLower(valString) == "now" {
-			dt = time.Now()
-		} else {
-			// TODO: trigger runtime error
-			return nil
-		}
+// normalizeEncoding returns the canonical name of the given encoding.
+func normalizeEncoding(enc stick.Value) string {
+	name := strings.ToUpper(strings.TrimSpace(stick.CoerceString(enc)))
+	switch name {
+	case "UTF8":
+		return "UTF-8"
+	case "UTF16":
+		return "UTF-16"
+	case "LATIN1", "ISO8859-1", "ISO-8859-1", "ISO_8859-1":
+		return "ISO-8859-1"
+	case "ASCII", "US-ASCII":
+		return "US-ASCII"
 	}
+	return name
+}
 
-	if l := len(args); l >= 1 {
-		requestedLayout = stick.CoerceString(args[0])
-	}
-
-	// build a golang date string
-	table := map[string]string{
-		"d": "02",
-		"D": "Mon",
-		"j": "2",
-		"l": "Monday",
-		"N": "", // TODO: ISO-8601 numeric representation of the day of the week (added in PHP 5.1.0)
-		"S": "ZZZ",
-		"w": "", // TODO: Numeric representation of the day of the week
-		"z": "", // TODO: The day of the year (starting from 0)
-		"W": "", // TODO: ISO-8601 week number of year, weeks starting on Monday (added in PHP 4.1.0)
-		"F": "January",
-		"m": "01",
-		"M": "Jan",
-		"n": "1",
-		"t": "", // TODO: Number of days in the given month
-		"L": "", // TODO: Whether it's a leap year
-		"o": "", // TODO: ISO-8601 year number. This has the same value as Y, except that if the ISO week number (W) belongs to the previous or next year, that year is used instead. (added in PHP 5.1.0)
-		"Y": "2006",
-		"y": "06",
-		"a": "pm",
-		"A": "PM",
-		"B": "", // TODO: Swatch Internet time (is this even still a thing?!)
-		"g": "3",
-		"G": "15",
-		"h": "03",
-		"H": "15",
-		"i": "04",
-		"s": "05",
-		"u": "000000",
-		"e": "", // TODO: Timezone identifier (added in PHP 5.1.0)
-		"I": "", // TODO: Whether or not the date is in daylight saving time
-		"O": "-0700",
-		"P": "-07:00",
-		"T": "MST",
-		"c": "2006-01-02T15:04:05-07:00",
-		"r": "Mon, 02 Jan 2006 15:04:05 -0700",
-		"U": "", // TODO: Seconds since the Unix Epoch (January 1 1970 00:00:00 GMT)
-	}
-	var layout string
-
-	maxLen := len(requestedLayout)
-	for i := 0; i < maxLen; i++ {
-		char := string(requestedLayout[i])
-		if t, ok := table[char]; ok {
-			layout += t
-			continue
+// decodeString returns the characters in s, which is in the given encoding.
+func decodeString(s string, enc string) ([]rune, bool) {
+	switch enc {
+	case "UTF-8":
+		return []rune(s), true
+	case "ISO-8859-1", "US-ASCII":
+		runes := make([]rune, len(s))
+		for i := 0; i < len(s); i++ {
+			if enc == "US-ASCII" && s[i] > unicode.MaxASCII {
+				runes[i] = '?'
+			} else {
+				runes[i] = rune(s[i])
+			}
 		}
-		if "\\" == char && i < maxLen-1 {
-			layout += string(requestedLayout[i+1])
-			continue
+		return runes, true
+	case "UTF-16", "UTF-16BE", "UTF-16LE":
+		b := []byte(s)
+		little := enc == "UTF-16LE"
+		if enc == "UTF-16" && len(b) >= 2 {
+			// Detect and remove the byte order mark, defaulting to big endian.
+			if b[0] == 0xFF && b[1] == 0xFE {
+				little = true
+				b = b[2:]
+			} else if b[0] == 0xFE && b[1] == 0xFF {
+				b = b[2:]
+			}
 		}
-		layout += char
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			if little {
+				units[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+			} else {
+				units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			}
+		}
+		return utf16.Decode(units), true
 	}
+	return nil, false
+}
 
-	toReturn := dt.Format(layout)
-
-	if strings.Contains(toReturn, "ZZZ") {
-		replace := "th"
-		dayIs := dt.Format("02")
-		if dayIs == "01" || dayIs == "21" || dayIs == "31" {
-			replace = "st"
-		} else if dayIs == "02" || dayIs == "22" {
-			replace = "nd"
-		} else if dayIs == "03" || dayIs == "23" {
-			replace = "rd"
+// encodeRunes returns the given characters in the given encoding.
+func encodeRunes(runes []rune, enc string) (string, bool) {
+	switch enc {
+	case "UTF-8":
+		return string(runes), true
+	case "ISO-8859-1", "US-ASCII":
+		max := rune(unicode.MaxLatin1)
+		if enc == "US-ASCII" {
+			max = unicode.MaxASCII
 		}
-		toReturn = strings.Replace(toReturn, "ZZZ", replace, 1)
+		b := make([]byte, len(runes))
+		for i, r := range runes {
+			if r > max {
+				b[i] = '?'
+			} else {
+				b[i] = byte(r)
+			}
+		}
+		return string(b), true
+	case "UTF-16", "UTF-16BE", "UTF-16LE":
+		units := utf16.Encode(runes)
+		b := make([]byte, 0, len(units)*2)
+		for _, u := range units {
+			if enc == "UTF-16LE" {
+				b = append(b, byte(u), byte(u>>8))
+			} else {
+				b = append(b, byte(u>>8), byte(u))
+			}
+		}
+		return string(b), true
 	}
-
-	return toReturn
+	return "", false
 }
 
-func filterDateModify(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+// filterDataURI takes two optional arguments, the MIME type and a hash of
+// parameters, and returns val as a data URI. If the MIME type is not given,
+// it is detected from val. Text is URL encoded, other data is base64 encoded.
+func filterDataURI(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	data := stick.CoerceString(val)
+	mime := ""
+	if len(args) > 0 && args[0] != nil {
+		mime = stick.CoerceString(args[0])
+	} else {
+		mime = http.DetectContentType([]byte(data))
+		if i := strings.IndexByte(mime, ';'); i >= 0 {
+			mime = mime[:i]
+		}
+	}
+	res := "data:" + mime
+	if len(args) > 1 {
+		// Parameters are sorted by name, as hashes are unordered.
+		params := make(map[string]string)
+		stick.Iterate(args[1], func(k, v stick.Value, l stick.Loop) (bool, error) {
+			params[stick.CoerceString(k)] = stick.CoerceString(v)
+			return false, nil
+		})
+		for _, k := range sortedKeys(params) {
+			res += ";" + k + "=" + escape.URLQueryParam(params[k])
+		}
+	}
+	if strings.HasPrefix(mime, "text/") {
+		return res + "," + escape.URLQueryParam(data)
+	}
+	return res + ";base64," + base64.StdEncoding.EncodeToString([]byte(data))
 }
 
 // filterDefault takes one argument, the default value. If val is empty,
@@ -239,6 +339,122 @@ func filterDefault(ctx stick.Context, val stick.Value, args ...stick.Value) stic
 	return val
 }
 
+// filterColumn takes the name of an attribute and returns the value of that
+// attribute for each element of val. If the second argument, index, is given,
+// the result is a map keyed by the value of the index attribute of each
+// element. Elements without the attribute are skipped.
+func filterColumn(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	if len(args) < 1 {
+		// TODO: Report error
+		return nil
+	}
+	var index stick.Value
+	if len(args) > 1 {
+		index = args[1]
+	}
+	var elements []stick.Value
+	if stick.IsMap(val) {
+		for _, k := range sortedKeys(val) {
+			v, _ := getAttr(ctx, val, k)
+			elements = append(elements, v)
+		}
+	} else {
+		_, err := stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+			elements = append(elements, v)
+			return false, nil
+		})
+		if err != nil {
+			// TODO: Report error
+			return nil
+		}
+	}
+
+	if index != nil {
+		out := make(map[string]stick.Value)
+		for _, el := range elements {
+			v, err := getAttr(ctx, el, args[0])
+			if err != nil {
+				continue
+			}
+			k, err := getAttr(ctx, el, index)
+			if err != nil {
+				continue
+			}
+			out[stick.CoerceString(k)] = v
+		}
+		return out
+	}
+	out := []stick.Value{}
+	for _, el := range elements {
+		if v, err := getAttr(ctx, el, args[0]); err == nil {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// filterFilter takes one argument, an arrow function called with each value
+// and key in val. Only the elements for which it returns true are kept.
+// Keys are preserved if val is a map.
+func filterFilter(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	if len(args) != 1 {
+		// TODO: Report error
+		return nil
+	}
+	if stick.IsMap(val) {
+		out := make(map[string]stick.Value)
+		_, err := stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+			keep, err := ctx.Call(args[0], v, k)
+			if err == nil && stick.CoerceBool(keep) {
+				out[stick.CoerceString(k)] = v
+			}
+			return false, err
+		})
+		if err != nil {
+			// TODO: Report error
+			return nil
+		}
+		return out
+	}
+	out := []stick.Value{}
+	_, err := stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+		keep, err := ctx.Call(args[0], v, k)
+		if err == nil && stick.CoerceBool(keep) {
+			out = append(out, v)
+		}
+		return false, err
+	})
+	if err != nil {
+		// TODO: Report error
+		return nil
+	}
+	return out
+}
+
+// filterFind takes one argument, an arrow function called with each value
+// and key in val. It returns the first value for which the function returns
+// true, or null if there is none.
+func filterFind(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	if len(args) != 1 {
+		// TODO: Report error
+		return nil
+	}
+	var res stick.Value
+	_, err := stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+		found, err := ctx.Call(args[0], v, k)
+		if err == nil && stick.CoerceBool(found) {
+			res = v
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		// TODO: Report error
+		return nil
+	}
+	return res
+}
+
 func filterFirst(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
 	if stick.IsArray(val) {
 		arr := reflect.ValueOf(val)
@@ -350,6 +566,40 @@ func filterLower(ctx stick.Context, val stick.Value, args ...stick.Value) stick.
 	return strings.ToLower(stick.CoerceString(val))
 }
 
+// filterMap takes one argument, an arrow function called with each value
+// and key in val. It returns the results of the function. Keys are
+// preserved if val is a map.
+func filterMap(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	if len(args) != 1 {
+		// TODO: Report error
+		return nil
+	}
+	if stick.IsMap(val) {
+		out := make(map[string]stick.Value)
+		_, err := stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+			res, err := ctx.Call(args[0], v, k)
+			out[stick.CoerceString(k)] = res
+			return false, err
+		})
+		if err != nil {
+			// TODO: Report error
+			return nil
+		}
+		return out
+	}
+	out := []stick.Value{}
+	_, err := stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+		res, err := ctx.Call(args[0], v, k)
+		out = append(out, res)
+		return false, err
+	})
+	if err != nil {
+		// TODO: Report error
+		return nil
+	}
+	return out
+}
+
 func filterMerge(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
 	if !stick.IsIterable(val) {
 		return nil
@@ -359,9 +609,15 @@ func filterMerge(ctx stick.Context, val stick.Value, args ...stick.Value) stick.
 		return nil
 	}
 
-	outMap, isObject := val.(map[string]stick.Value)
+	inMap, isObject := val.(map[string]stick.Value)
 
 	if isObject {
+		// Copy the input to avoid modifying a value that may be shared.
+		outMap := make(map[string]stick.Value, len(inMap))
+		for k, v := range inMap {
+			outMap[k] = v
+		}
+
 		argMap, ok := args[0].(map[string]stick.Value)
 
 		if ok {
@@ -388,20 +644,116 @@ func filterMerge(ctx stick.Context, val stick.Value, args ...stick.Value) stick.
 	}
 }
 
+// filterNL2BR returns val with "<br />" inserted before each newline. Value
+// val is escaped for HTML unless it is already safe, and the result is safe.
 func filterNL2BR(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+	s := stick.CoerceString(val)
+	if sv, ok := val.(stick.SafeValue); !ok || !sv.IsSafe("html") {
+		s = escape.HTML(s)
+	}
+	res := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\n' && c != '\r' {
+			res = append(res, c)
+			continue
+		}
+		res = append(res, "<br />"...)
+		res = append(res, c)
+		// "\r\n" and "\n\r" are treated as a single newline.
+		if i+1 < len(s) && (s[i+1] == '\n' || s[i+1] == '\r') && s[i+1] != c {
+			i++
+			res = append(res, s[i])
+		}
+	}
+	return stick.NewSafeValue(string(res), "html")
 }
 
+// filterNumberFormat takes three optional arguments: the number of decimal
+// places (defaults to 0), the decimal point (defaults to "."), and the
+// thousands separator (defaults to ","). Value val is rounded half away from
+// zero, as in PHP.
 func filterNumberFormat(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+	decimals := 0
+	point, sep := ".", ","
+	if len(args) > 0 && args[0] != nil {
+		decimals = int(stick.CoerceNumber(args[0]))
+		if decimals < 0 {
+			decimals = 0
+		}
+	}
+	if len(args) > 1 && args[1] != nil {
+		point = stick.CoerceString(args[1])
+	}
+	if len(args) > 2 && args[2] != nil {
+		sep = stick.CoerceString(args[2])
+	}
+	return formatNumber(stick.CoerceNumber(val), decimals, point, sep)
+}
+
+// formatNumber formats n with the given number of decimal places, decimal
+// point and thousands separator.
+func formatNumber(n float64, decimals int, point, sep string) string {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return fmt.Sprint(n)
+	}
+	return groupNumber(decimal.NewFromFloat(n).StringFixed(int32(decimals)), point, sep)
+}
+
+// groupNumber formats the decimal string s, such as "-1234.50", with the
+// given decimal point and thousands separator.
+func groupNumber(s string, point, sep string) string {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	res := ""
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			res += sep
+		}
+		res += string(c)
+	}
+	if fracPart != "" {
+		res += point + fracPart
+	}
+	if neg && strings.Trim(s, "0.") != "" {
+		res = "-" + res
+	}
+	return res
 }
 
 func filterRaw(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
 	return stick.NewSafeValue(stick.CoerceString(val), "html", "html_attr", "js", "css", "url")
 }
 
+// filterReduce takes an arrow function and an optional initial value. The
+// function is called with the result of the previous call, or the initial
+// value, and each value and key in val. The result of the last call is
+// returned.
+func filterReduce(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	if len(args) < 1 {
+		// TODO: Report error
+		return nil
+	}
+	var carry stick.Value
+	if len(args) > 1 {
+		carry = args[1]
+	}
+	_, err := stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+		res, err := ctx.Call(args[0], carry, v, k)
+		carry = res
+		return false, err
+	})
+	if err != nil {
+		// TODO: Report error
+		return nil
+	}
+	return carry
+}
+
 func filterReplace(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
 	if len(args) != 1 {
 		return val
@@ -474,24 +826,342 @@ func filterRound(ctx stick.Context, val stick.Value, args ...stick.Value) stick.
 	}
 }
 
+// filterSlice takes a start offset and an optional length, and returns that
+// part of val. A negative start counts from the end of val, and a negative
+// length stops that many elements from the end. Value val may be a string,
+// an array, or a map, whose keys are taken in sorted order. Keys are
+// preserved for maps, and for arrays only if the third argument,
+// preserve_keys, is true.
 func filterSlice(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+	if len(args) < 1 {
+		// TODO: Report error
+		return nil
+	}
+	var length *int
+	if len(args) > 1 && args[1] != nil {
+		l := int(stick.CoerceNumber(args[1]))
+		length = &l
+	}
+	preserveKeys := len(args) > 2 && stick.CoerceBool(args[2])
+
+	if stick.IsMap(val) {
+		keys := sortedKeys(val)
+		from, to := sliceBounds(len(keys), int(stick.CoerceNumber(args[0])), length)
+		res := make(map[string]stick.Value, to-from)
+		for _, k := range keys[from:to] {
+			v, _ := getAttr(ctx, val, k)
+			res[k] = v
+		}
+		return res
+	}
+	if stick.IsArray(val) {
+		r := reflect.ValueOf(val)
+		from, to := sliceBounds(r.Len(), int(stick.CoerceNumber(args[0])), length)
+		if preserveKeys {
+			res := make(map[string]stick.Value, to-from)
+			for i := from; i < to; i++ {
+				res[strconv.Itoa(i)] = r.Index(i).Interface()
+			}
+			return res
+		}
+		res := make([]stick.Value, 0, to-from)
+		for i := from; i < to; i++ {
+			res = append(res, r.Index(i).Interface())
+		}
+		return res
+	}
+	runes := []rune(stick.CoerceString(val))
+	from, to := sliceBounds(len(runes), int(stick.CoerceNumber(args[0])), length)
+	return string(runes[from:to])
+}
+
+// sliceBounds returns the bounds of the part of a sequence of length n
+// starting at start, with the given length, as in PHP's array_slice.
+func sliceBounds(n, start int, length *int) (int, int) {
+	if start < 0 {
+		start += n
+		if start < 0 {
+			start = 0
+		}
+	}
+	if start > n {
+		start = n
+	}
+	end := n
+	if length != nil {
+		if *length < 0 {
+			end = n + *length
+		} else {
+			end = start + *length
+		}
+	}
+	if end > n {
+		end = n
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// getAttr returns the attribute attr of val, looked up through ctx so that
+// the sandbox policy applies. A *stick.SecurityError is also reported with
+// ctx.Fail, so that a filter skipping missing attributes cannot hide it.
+func getAttr(ctx stick.Context, val stick.Value, attr stick.Value) (stick.Value, error) {
+	if ctx == nil {
+		return stick.GetAttr(val, attr)
+	}
+	v, err := ctx.GetAttr(val, attr)
+	if _, ok := err.(*stick.SecurityError); ok {
+		ctx.Fail(err)
+	}
+	return v, err
 }
 
+// sortedKeys returns the keys of the map val, as strings, in sorted order.
+func sortedKeys(val stick.Value) []string {
+	var keys []string
+	stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+		keys = append(keys, stick.CoerceString(k))
+		return false, nil
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// valueSorter sorts values with a comparison function.
+type valueSorter struct {
+	values  []stick.Value
+	compare func(a, b stick.Value) int
+}
+
+func (s *valueSorter) Len() int           { return len(s.values) }
+func (s *valueSorter) Swap(i, j int)      { s.values[i], s.values[j] = s.values[j], s.values[i] }
+func (s *valueSorter) Less(i, j int) bool { return s.compare(s.values[i], s.values[j]) < 0 }
+
+// filterSort returns the values in val, sorted. An optional arrow function
+// may be given, which is called with two values and returns a negative
+// number, zero, or a positive number if the first is less than, equal to, or
+// greater than the second. Otherwise, numbers and numeric strings are
+// compared as numbers, and other values as strings. As Go maps are
+// unordered, the values of a map are returned as an array.
 func filterSort(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+	if !stick.IsIterable(val) {
+		// TODO: Report error
+		return nil
+	}
+	var values []stick.Value
+	if stick.IsMap(val) {
+		// Start from sorted keys so that the result does not depend on map order.
+		for _, k := range sortedKeys(val) {
+			v, _ := getAttr(ctx, val, k)
+			values = append(values, v)
+		}
+	} else {
+		stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+			values = append(values, v)
+			return false, nil
+		})
+	}
+
+	var err error
+	compare := compareValues
+	if len(args) > 0 && args[0] != nil {
+		compare = func(a, b stick.Value) int {
+			res, e := ctx.Call(args[0], a, b)
+			if e != nil && err == nil {
+				err = e
+			}
+			return int(stick.CoerceNumber(res))
+		}
+	}
+	sort.Stable(&valueSorter{values, compare})
+	if err != nil {
+		// TODO: Report error
+		return nil
+	}
+	if values == nil {
+		values = []stick.Value{}
+	}
+	return values
+}
+
+// compareValues compares a and b, returning -1, 0 or 1. Numbers and numeric
+// strings are compared as numbers, and other values as strings.
+func compareValues(a, b stick.Value) int {
+	af, aok := numericValue(a)
+	bf, bok := numericValue(b)
+	if aok && bok {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(stick.CoerceString(a), stick.CoerceString(b))
+}
+
+// numericValue returns the value of v as a number, and whether v is a number
+// or a numeric string.
+func numericValue(v stick.Value) (float64, bool) {
+	switch vc := v.(type) {
+	case nil, bool:
+		return stick.CoerceNumber(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(vc), 64)
+		return f, err == nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return stick.CoerceNumber(v), true
+	}
+	return 0, false
 }
 
+// filterSplit takes a delimiter and an optional limit, and returns val split
+// into an array of strings. If limit is positive, at most limit elements are
+// returned, the last containing the rest of val. If limit is negative, all
+// but the last -limit elements are returned. If the delimiter is empty, val
+// is split into chunks of limit characters.
 func filterSplit(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+	if len(args) < 1 {
+		// TODO: Report error
+		return nil
+	}
+	s := stick.CoerceString(val)
+	delim := stick.CoerceString(args[0])
+	limit := 0
+	hasLimit := len(args) > 1 && args[1] != nil
+	if hasLimit {
+		// There are at most len(s)+1 parts, so larger limits are equivalent.
+		// Clamping them keeps the limit and its negation within an int.
+		n := stick.CoerceNumber(args[1])
+		max := float64(len(s) + 1)
+		switch {
+		case math.IsNaN(n):
+			n = 0
+		case n > max:
+			n = max
+		case n < -max:
+			n = -max
+		}
+		limit = int(n)
+	}
+
+	var parts []string
+	if delim == "" {
+		size := 1
+		if limit > 1 {
+			size = limit
+		}
+		runes := []rune(s)
+		for i := 0; i < len(runes); i += size {
+			end := i + size
+			if end > len(runes) {
+				end = len(runes)
+			}
+			parts = append(parts, string(runes[i:end]))
+		}
+	} else if !hasLimit {
+		parts = strings.Split(s, delim)
+	} else if limit >= 0 {
+		if limit == 0 {
+			limit = 1
+		}
+		parts = strings.SplitN(s, delim, limit)
+	} else {
+		parts = strings.Split(s, delim)
+		if -limit >= len(parts) {
+			parts = nil
+		} else {
+			parts = parts[:len(parts)+limit]
+		}
+	}
+
+	res := make([]stick.Value, len(parts))
+	for i, p := range parts {
+		res[i] = p
+	}
+	return res
 }
 
+// filterStripTags returns val with HTML and PHP tags and HTML comments
+// removed. An optional argument lists the tags that should be kept, such as
+// "<br><p>".
 func filterStripTags(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	// TODO: Implement Me
-	return val
+	allowed := make(map[string]bool)
+	if len(args) > 0 {
+		for _, m := range allowedTagPattern.FindAllStringSubmatch(stick.CoerceString(args[0]), -1) {
+			allowed[strings.ToLower(m[1])] = true
+		}
+	}
+	s := stick.CoerceString(val)
+	res := ""
+	for {
+		i := strings.IndexByte(s, '<')
+		if i < 0 || i == len(s)-1 {
+			return res + s
+		}
+		res += s[:i]
+		s = s[i:]
+		c := s[1]
+		if !(c == '/' || c == '!' || c == '?' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			// Not a tag, such as in "a < b".
+			res += "<"
+			s = s[1:]
+			continue
+		}
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				return res
+			}
+			s = s[end+3:]
+			continue
+		}
+		end := tagEnd(s)
+		if end < 0 {
+			// An unterminated tag removes the rest of val.
+			return res
+		}
+		tag := s[:end+1]
+		if m := tagNamePattern.FindStringSubmatch(tag); m != nil && allowed[strings.ToLower(m[1])] {
+			res += tag
+		}
+		s = s[end+1:]
+	}
+}
+
+var (
+	allowedTagPattern = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)`)
+	tagNamePattern    = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9-]*)`)
+)
+
+// tagEnd returns the index of the ">" ending the tag at the start of s,
+// ignoring any in quoted attribute values, or -1 if the tag is unterminated.
+func tagEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i
+		}
+	}
+	return -1
+}
+
+// filterSpaceless returns val with whitespace between HTML tags removed,
+// as the spaceless tag does. Whitespace within text is left as is. The result
+// is safe.
+func filterSpaceless(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	return stick.NewSafeValue(stick.Spaceless(stick.CoerceString(val)), "html")
 }
 
 // filterTitle returns val with the first character of each word capitalized.
@@ -499,9 +1169,29 @@ func filterTitle(ctx stick.Context, val stick.Value, args ...stick.Value) stick.
 	return strings.Title(stick.CoerceString(val))
 }
 
-// filterTrim returns val with whitespace trimmed on both left and ride sides.
+// filterTrim takes two optional arguments, the characters to trim, and the
+// side to trim them from: "left", "right", or "both", the default. By default,
+// whitespace and NUL characters are trimmed.
 func filterTrim(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
-	return strings.TrimSpace(stick.CoerceString(val))
+	s := stick.CoerceString(val)
+	chars := " \t\n\r\x00\x0B"
+	if len(args) > 0 && args[0] != nil {
+		chars = stick.CoerceString(args[0])
+	}
+	side := "both"
+	if len(args) > 1 && args[1] != nil {
+		side = stick.CoerceString(args[1])
+	}
+	switch side {
+	case "left":
+		return strings.TrimLeft(s, chars)
+	case "right":
+		return strings.TrimRight(s, chars)
+	case "both":
+		return strings.Trim(s, chars)
+	}
+	// TODO: Report error
+	return nil
 }
 
 // filterUpper returns val in upper-case.
@@ -1,7 +1,9 @@
 package filter
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"strings"
 	"testing"
 	"time"
@@ -51,6 +53,10 @@ func TestFilters(t *testing.T) {
 		{"lower", func() stick.Value { return filterLower(nil, "HELLO, WORLD!") }, "hello, world!"},
 		{"title", func() stick.Value { return filterTitle(nil, "hello, world!") }, "Hello, World!"},
 		{"trim", func() stick.Value { return filterTrim(nil, " Hello   ") }, "Hello"},
+		{"trim characters", func() stick.Value { return filterTrim(nil, "  I like Twig.", ".") }, "  I like Twig"},
+		{"trim left", func() stick.Value { return filterTrim(nil, "  I like Twig.  ", nil, "left") }, "I like Twig.  "},
+		{"trim right", func() stick.Value { return filterTrim(nil, "xxI like Twig.xx", "x", "right") }, "xxI like Twig."},
+		{"trim invalid side", func() stick.Value { return filterTrim(nil, " a ", nil, "middle") }, nil},
 		{"upper", func() stick.Value { return filterUpper(nil, "hello, world!") }, "HELLO, WORLD!"},
 		{"batch underfull with fill", newBatchFunc([]int{1, 2, 3, 4, 5, 6, 7, 8}, 3, "No Item"), "1.2.3..4.5.6..7.8.No Item.."},
 		{"batch underfull without fill", newBatchFunc([]int{1, 2, 3, 4, 5}, 3), "1.2.3..4.5.."},
@@ -65,10 +71,48 @@ func TestFilters(t *testing.T) {
 		{"last string utf8", func() stick.Value { return filterLast(nil, "東京") }, "京"},
 		{"date c", func() stick.Value { return filterDate(nil, testDate, "c") }, "1980-05-31T22:01:00+08:00"},
 		{"date r", func() stick.Value { return filterDate(nil, testDate, "r") }, "Sat, 31 May 1980 22:01:00 +0800"},
-		{"date test", func() stick.Value { return filterDate(nil, testDate2, "d D j l F m M n Y y a A g G h H i s O P T") }, "03 Sat 3 Saturday February 02 Feb 2 2018 18 am AM 2 02 02 02 01 44 +0800 +08:00 AWST"},
+		{"date test", func() stick.Value { return filterDate(nil, testDate2, "d D j l F m M n Y y a A g G h H i s O P T") }, "03 Sat 3 Saturday February 02 Feb 2 2018 18 am AM 2 2 02 02 01 44 +0800 +08:00 AWST"},
 		{"date u", func() stick.Value { return filterDate(nil, testDate2, "s.u") }, "44.123456"},
 		{"date S", func() stick.Value { return filterDate(nil, testDate, "S") }, "st"},
 		{"date S 2", func() stick.Value { return filterDate(nil, testDate2, "S") }, "rd"},
+		{"date day", func() stick.Value { return filterDate(nil, testDate2, "N w z S") }, "6 6 33 rd"},
+		{"date week", func() stick.Value { return filterDate(nil, time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), "W o Y N") }, "53 2020 2021 7"},
+		{"date month", func() stick.Value { return filterDate(nil, testDate2, "t L") }, "28 0"},
+		{"date leap year", func() stick.Value { return filterDate(nil, time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC), "t L") }, "29 1"},
+		{"date year", func() stick.Value { return filterDate(nil, time.Date(987, 1, 1, 0, 0, 0, 0, time.UTC), "Y y X x") }, "0987 87 +0987 0987"},
+		{"date time", func() stick.Value { return filterDate(nil, testDate2, "B g G h H v") }, "792 2 2 02 02 123"},
+		{"date timezone", func() stick.Value { return filterDate(nil, testDate2, "e I O P p T Z") }, "Australia/Perth 0 +0800 +08:00 +08:00 AWST 28800"},
+		{"date utc", func() stick.Value { return filterDate(nil, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), "e p Z") }, "UTC Z 0"},
+		{"date dst", func() stick.Value {
+			return filterDate(nil, time.Date(2018, 7, 1, 12, 0, 0, 0, time.UTC), "I T", "Europe/London").(string) + " " +
+				filterDate(nil, time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC), "I T", "Europe/London").(string)
+		}, "1 BST 0 GMT"},
+		{"format_datetime", func() stick.Value { return filterFormatDateTime(nil, testDate2) }, "Feb 3, 2018, 2:01:44 AM"},
+		{"format_datetime full", func() stick.Value { return filterFormatDateTime(nil, testDate2, "full", "full") }, "Saturday, February 3, 2018 at 2:01:44 AM AWST"},
+		{"format_datetime pattern", func() stick.Value {
+			return filterFormatDateTime(nil, testDate2, nil, nil, "yyyy-MM-dd HH:mm:ss.SSS 'o''clock' z", "UTC")
+		}, "2018-02-02 18:01:44.123 o'clock UTC"},
+		{"format_datetime invalid", func() stick.Value { return filterFormatDateTime(nil, testDate2, "tiny") }, nil},
+		{"format_date", func() stick.Value { return filterFormatDate(nil, testDate2) }, "Feb 3, 2018"},
+		{"format_date short", func() stick.Value { return filterFormatDate(nil, testDate2, "short") }, "2/3/18"},
+		{"format_date long", func() stick.Value { return filterFormatDate(nil, testDate2, "long") }, "February 3, 2018"},
+		{"format_time", func() stick.Value { return filterFormatTime(nil, testDate2) }, "2:01:44 AM"},
+		{"format_time short", func() stick.Value { return filterFormatTime(nil, testDate2, "short", nil, "UTC") }, "6:01 PM"},
+		{"data_uri text", func() stick.Value { return filterDataURI(nil, "Hello, World!") }, "data:text/plain,Hello%2C%20World%21"},
+		{"data_uri binary", func() stick.Value {
+			return filterDataURI(nil, "\x89PNG", "image/png", map[string]stick.Value{"name": "a b"})
+		}, "data:image/png;name=a%20b;base64,iVBORw=="},
+		{"date U", func() stick.Value { return filterDate(nil, testDate2, "U") }, "1517594504"},
+		{"date escaped", func() stick.Value { return filterDate(nil, testDate2, `l \t\h\e jS`) }, "Saturday the 3rd"},
+		{"date default format", func() stick.Value { return filterDate(nil, testDate2) }, "February 3, 2018 02:01"},
+		{"date timezone argument", func() stick.Value { return filterDate(nil, testDate2, "Y-m-d H:i T", "UTC") }, "2018-02-02 18:01 UTC"},
+		{"date timezone false", func() stick.Value { return filterDate(nil, testDate2, "H:i T", false) }, "02:01 AWST"},
+		{"date invalid timezone", func() stick.Value { return filterDate(nil, testDate2, "H:i", "Not/AZone") }, nil},
+		{"date unix", func() stick.Value { return filterDate(nil, 1517594504, "Y-m-d H:i:s", "UTC") }, "2018-02-02 18:01:44"},
+		{"date unix string", func() stick.Value { return filterDate(nil, "1517594504", "Y-m-d H:i:s", "UTC") }, "2018-02-02 18:01:44"},
+		{"date string", func() stick.Value { return filterDate(nil, "2018-02-03T02:01:44Z", "c", "Australia/Perth") }, "2018-02-03T10:01:44+08:00"},
+		{"date string with offset", func() stick.Value { return filterDate(nil, "2018-02-03T02:01:44+08:00", "H:i", "UTC") }, "18:01"},
+		{"date invalid", func() stick.Value { return filterDate(nil, "not a date", "Y") }, nil},
 		{"date now", func() stick.Value { return filterDate(nil, "now", "Y-m-d") }, time.Now().Format("2006-01-02")},
 		{"join", func() stick.Value { return filterJoin(nil, []string{"a", "b", "c"}, "-") }, "a-b-c"},
 		{"round common down", func() stick.Value { return filterRound(nil, 3.4) }, 3.0},
@@ -130,6 +174,139 @@ func TestFilters(t *testing.T) {
 				return
 			},
 		},
+		{"slice string", func() stick.Value { return filterSlice(nil, "12345", 1, 2) }, "23"},
+		{"slice string negative", func() stick.Value { return filterSlice(nil, "12345", -4, 2) }, "23"},
+		{"slice string negative length", func() stick.Value { return filterSlice(nil, "東京都庁", 1, -1) }, "京都"},
+		{"slice string out of range", func() stick.Value { return filterSlice(nil, "123", 5) }, ""},
+		{"slice array", func() stick.Value { return stickSliceToString(filterSlice(nil, []int{1, 2, 3, 4, 5}, 1, 2)) }, "2.3"},
+		{"slice array negative", func() stick.Value { return stickSliceToString(filterSlice(nil, []int{1, 2, 3, 4, 5}, -2)) }, "4.5"},
+		{"slice array preserve keys", func() stick.Value { return filterSlice(nil, []int{1, 2, 3}, 1, nil, true) }, "map[1:2 2:3]"},
+		{"slice map", func() stick.Value {
+			return filterSlice(nil, map[string]stick.Value{"a": 1, "b": 2, "c": 3}, 1, 1)
+		}, "map[b:2]"},
+		{"sort", func() stick.Value { return stickSliceToString(filterSort(nil, []int{3, 1, 2})) }, "1.2.3"},
+		{"sort strings", func() stick.Value { return stickSliceToString(filterSort(nil, []string{"b", "10", "a", "9"})) }, "9.10.a.b"},
+		{"sort map", func() stick.Value {
+			return stickSliceToString(filterSort(nil, map[string]stick.Value{"x": 3, "y": 1, "z": 2}))
+		}, "1.2.3"},
+		{"sort invalid", func() stick.Value { return filterSort(nil, "abc") }, nil},
+		{"split", func() stick.Value { return stickSliceToString(filterSplit(nil, "one,two,three", ",")) }, "one.two.three"},
+		{"split limit", func() stick.Value { return filterSplit(nil, "one,two,three,four,five", ",", 3) }, "[one two three,four,five]"},
+		{"split negative limit", func() stick.Value { return filterSplit(nil, "one,two,three,four,five", ",", -1) }, "[one two three four]"},
+		{"split zero limit", func() stick.Value { return filterSplit(nil, "one,two", ",", 0) }, "[one,two]"},
+		{"split empty", func() stick.Value { return filterSplit(nil, "123", "") }, "[1 2 3]"},
+		{"split empty limit", func() stick.Value { return filterSplit(nil, "aabbcc", "", 2) }, "[aa bb cc]"},
+		{"split infinite limit", func() stick.Value { return filterSplit(nil, "a,b,c", ",", math.Inf(1)) }, "[a b c]"},
+		{"split negative infinite limit", func() stick.Value { return filterSplit(nil, "a,b,c", ",", math.Inf(-1)) }, "[]"},
+		{"split NaN limit", func() stick.Value { return filterSplit(nil, "a,b,c", ",", math.NaN()) }, "[a,b,c]"},
+		{"split empty infinite limit", func() stick.Value { return filterSplit(nil, "abc", "", math.Inf(1)) }, "[abc]"},
+		{"striptags", func() stick.Value {
+			return filterStripTags(nil, `<p class="a>b">Hello <b>World</b>!</p><!-- comment --><?php echo 1; ?>`)
+		}, "Hello World!"},
+		{"striptags allowed", func() stick.Value {
+			return filterStripTags(nil, `<p>Hello<br/>there <em>you</em></p>`, "<br><p>")
+		}, "<p>Hello<br/>there you</p>"},
+		{"striptags not a tag", func() stick.Value { return filterStripTags(nil, "1 < 2 and 3 > 2") }, "1 < 2 and 3 > 2"},
+		{"striptags unterminated", func() stick.Value { return filterStripTags(nil, "Hello <b World") }, "Hello "},
+		{"nl2br", func() stick.Value {
+			return filterNL2BR(nil, "a\nb & c\r\nd").(stick.SafeValue).Value()
+		}, "a<br />\nb &amp; c<br />\r\nd"},
+		{"nl2br safe", func() stick.Value {
+			return filterNL2BR(nil, stick.NewSafeValue("<b>a</b>\n", "html")).(stick.SafeValue).Value()
+		}, "<b>a</b><br />\n"},
+		{"number_format", func() stick.Value { return filterNumberFormat(nil, 9800.333, 2, ".", ",") }, "9,800.33"},
+		{"number_format default", func() stick.Value { return filterNumberFormat(nil, 1234567.5) }, "1,234,568"},
+		{"number_format separators", func() stick.Value { return filterNumberFormat(nil, 1234.5678, 2, ",", ".") }, "1.234,57"},
+		{"number_format half", func() stick.Value { return filterNumberFormat(nil, 1.005, 2) }, "1.01"},
+		{"number_format negative", func() stick.Value { return filterNumberFormat(nil, -1234.5) }, "-1,235"},
+		{"number_format negative zero", func() stick.Value { return filterNumberFormat(nil, -0.4) }, "0"},
+		{"number_format string", func() stick.Value { return filterNumberFormat(nil, "1000", 1, ".", " ") }, "1 000.0"},
+		{"date_modify", func() stick.Value { return filterDate(nil, filterDateModify(nil, testDate, "+1 day"), "Y-m-d H:i") }, "1980-06-01 22:01"},
+		{"date_modify months", func() stick.Value {
+			return filterDate(nil, filterDateModify(nil, testDate, "-2 months 3 days"), "Y-m-d")
+		}, "1980-04-03"},
+		{"date_modify ago", func() stick.Value { return filterDate(nil, filterDateModify(nil, testDate, "1 week ago"), "Y-m-d") }, "1980-05-24"},
+		{"date_modify compact", func() stick.Value { return filterDate(nil, filterDateModify(nil, testDate, "+2hours"), "Y-m-d H:i") }, "1980-06-01 00:01"},
+		{"date_modify tomorrow", func() stick.Value { return filterDate(nil, filterDateModify(nil, testDate, "tomorrow"), "Y-m-d H:i") }, "1980-06-01 00:00"},
+		{"date_modify next weekday", func() stick.Value { return filterDate(nil, filterDateModify(nil, testDate, "next monday"), "Y-m-d D") }, "1980-06-02 Mon"},
+		{"date_modify last weekday", func() stick.Value {
+			return filterDate(nil, filterDateModify(nil, testDate, "last saturday"), "Y-m-d D")
+		}, "1980-05-24 Sat"},
+		{"date_modify first day of", func() stick.Value {
+			return filterDate(nil, filterDateModify(nil, testDate, "first day of next month"), "Y-m-d")
+		}, "1980-06-01"},
+		{"date_modify last day of", func() stick.Value { return filterDate(nil, filterDateModify(nil, testDate2, "last day of"), "Y-m-d") }, "2018-02-28"},
+		{"date_modify string", func() stick.Value { return filterDate(nil, filterDateModify(nil, "2020-02-28", "+1 day"), "Y-m-d") }, "2020-02-29"},
+		{"date_modify invalid", func() stick.Value { return filterDateModify(nil, testDate, "+1 fortnights and a bit") }, nil},
+		{"convert_encoding latin1", func() stick.Value { return filterConvertEncoding(nil, "café", "ISO-8859-1", "UTF-8") }, "caf\xe9"},
+		{"convert_encoding from latin1", func() stick.Value { return filterConvertEncoding(nil, "caf\xe9", "UTF-8", "latin1") }, "café"},
+		{"convert_encoding ascii", func() stick.Value { return filterConvertEncoding(nil, "café", "ASCII", "UTF-8") }, "caf?"},
+		{"convert_encoding utf16", func() stick.Value { return filterConvertEncoding(nil, "hé", "UTF-16LE", "UTF-8") }, "h\x00\xe9\x00"},
+		{"convert_encoding utf16 bom", func() stick.Value { return filterConvertEncoding(nil, "\xff\xfeh\x00", "utf8", "UTF-16") }, "h"},
+		{"convert_encoding unknown", func() stick.Value { return filterConvertEncoding(nil, "abc", "EBCDIC", "UTF-8") }, nil},
+		{"column", func() stick.Value {
+			return filterColumn(nil, []stick.Value{
+				map[string]stick.Value{"fruit": "apple", "id": 1},
+				map[string]stick.Value{"fruit": "orange", "id": 2},
+				map[string]stick.Value{"id": 3},
+			}, "fruit")
+		}, "[apple orange]"},
+		{"column index", func() stick.Value {
+			return filterColumn(nil, []stick.Value{
+				map[string]stick.Value{"fruit": "apple", "id": 1},
+				map[string]stick.Value{"fruit": "orange", "id": 2},
+			}, "fruit", "id")
+		}, "map[1:apple 2:orange]"},
+		{"spaceless", func() stick.Value {
+			return filterSpaceless(nil, "\n<div>\n    <strong>foo  bar</strong>\n</div>\n").(stick.SafeValue).Value()
+		}, "<div><strong>foo  bar</strong></div>"},
+		{"format_number", func() stick.Value { return filterFormatNumber(nil, 1234.5678) }, "1,234.568"},
+		{"format_number NaN", func() stick.Value { return filterFormatNumber(nil, math.NaN()) }, "NaN"},
+		{"format_number Inf", func() stick.Value { return filterFormatNumber(nil, math.Inf(1)) }, "+Inf"},
+		{"format_number negative digits", func() stick.Value {
+			return filterFormatNumber(nil, 100, map[string]stick.Value{"min_fraction_digit": -1, "max_fraction_digit": 0})
+		}, nil},
+		{"format_number trailing zeros", func() stick.Value { return filterFormatNumber(nil, "12.50") }, "12.5"},
+		{"format_number percent", func() stick.Value { return filterFormatNumber(nil, 0.1234, nil, "percent") }, "12%"},
+		{"format_number attrs", func() stick.Value {
+			return filterFormatNumber(nil, 1234.5, map[string]stick.Value{"fraction_digit": 2, "grouping_used": false})
+		}, "1234.50"},
+		{"format_number half even", func() stick.Value {
+			return filterFormatNumber(nil, 2.5, map[string]stick.Value{"max_fraction_digit": 0})
+		}, "2"},
+		{"format_number locale", func() stick.Value { return filterFormatNumber(nil, 1234.5, nil, nil, nil, "de_DE") }, "1.234,5"},
+		{"format_number percent locale", func() stick.Value { return filterFormatNumber(nil, 0.5, nil, "percent", nil, "fr") }, "50\u00a0%"},
+		{"format_number unknown style", func() stick.Value { return filterFormatNumber(nil, 1, nil, "spellout") }, nil},
+		{"format_number unknown attr", func() stick.Value {
+			return filterFormatNumber(nil, 1, map[string]stick.Value{"padding_character": "*"})
+		}, nil},
+		{"format_currency", func() stick.Value { return filterFormatCurrency(nil, 1000000, "EUR") }, "€1,000,000.00"},
+		{"format_currency empty code", func() stick.Value { return filterFormatCurrency(nil, 10, "") }, nil},
+		{"format_currency null code", func() stick.Value { return filterFormatCurrency(nil, 10, nil) }, nil},
+		{"format_currency NaN", func() stick.Value { return filterFormatCurrency(nil, math.NaN(), "USD") }, nil},
+		{"format_currency negative", func() stick.Value { return filterFormatCurrency(nil, -12.346, "USD") }, "-$12.35"},
+		{"format_currency no decimals", func() stick.Value { return filterFormatCurrency(nil, 1234.5, "JPY") }, "¥1,234"},
+		{"format_currency code", func() stick.Value { return filterFormatCurrency(nil, 10, "CHF") }, "CHF\u00a010.00"},
+		{"format_currency unknown", func() stick.Value { return filterFormatCurrency(nil, 10, "xyz") }, "XYZ\u00a010.00"},
+		{"format_currency locale", func() stick.Value { return filterFormatCurrency(nil, 1000000, "EUR", nil, "de") }, "1.000.000,00\u00a0€"},
+		{"format_currency attrs", func() stick.Value {
+			return filterFormatCurrency(nil, 3.5, "USD", map[string]stick.Value{"fraction_digit": 0})
+		}, "$4"},
+		{"markdown_to_html", func() stick.Value {
+			return filterMarkdownToHTML(nil, "\n    # Title\n\n    Hello *there* & <b>welcome</b>\n    to **[Stick](https://example.com)**.\n").(stick.SafeValue).Value()
+		}, "<h1>Title</h1>\n<p>Hello <em>there</em> &amp; &lt;b&gt;welcome&lt;/b&gt;\nto <strong><a href=\"https://example.com\">Stick</a></strong>.</p>\n"},
+		{"markdown_to_html lists", func() stick.Value {
+			return filterMarkdownToHTML(nil, "- one\n- two\n  - nested\n\n3. three\n4. four").(stick.SafeValue).Value()
+		}, "<ul>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul>\n</li>\n</ul>\n<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n"},
+		{"markdown_to_html blocks", func() stick.Value {
+			return filterMarkdownToHTML(nil, "Title\n===\n\n> quote\n\n```go\nif a < b {}\n```\n\n---\n\n    code\n\nsnake_case_word `x` ![alt](/a.png)  \nend").(stick.SafeValue).Value()
+		}, "<h1>Title</h1>\n<blockquote>\n<p>quote</p>\n</blockquote>\n<pre><code class=\"language-go\">if a &lt; b {}\n</code></pre>\n<hr />\n<pre><code>code\n</code></pre>\n<p>snake_case_word <code>x</code> <img src=\"/a.png\" alt=\"alt\" /><br />\nend</p>\n"},
+		{"markdown_to_html unsafe links", func() stick.Value {
+			return filterMarkdownToHTML(nil, "[x](javascript:alert(1)) [y](JavaScript:alert) ![z](data:image/png;base64,AA==) [m](mailto:a@b.c)").(stick.SafeValue).Value()
+		}, "<p>x y z <a href=\"mailto:a@b.c\">m</a></p>\n"},
+		{"markdown_to_html balanced parentheses", func() stick.Value {
+			return filterMarkdownToHTML(nil, "[wiki](https://en.wikipedia.org/wiki/Go_(language) \"Go (lang)\") and (text)").(stick.SafeValue).Value()
+		}, "<p><a href=\"https://en.wikipedia.org/wiki/Go_(language)\" title=\"Go (lang)\">wiki</a> and (text)</p>\n"},
 		{"urlencode", func() stick.Value { return filterURLEncode(nil, "http://test.com/dude?sweet=33&1=2") }, "http%3A%2F%2Ftest.com%2Fdude%3Fsweet%3D33%261%3D2"},
 		{"raw", func() stick.Value {
 			safeVal, ok := filterRaw(nil, "<p>test</p>").(stick.SafeValue)
@@ -173,3 +350,99 @@ func stickSliceToString(value stick.Value) (output string) {
 
 	return strings.Join(slice, ".")
 }
+
+func TestExecuteFilters(t *testing.T) {
+	env := stick.New(nil)
+	env.Filters = TwigFilters()
+	env.FilterParams = TwigFilterParams()
+	tz, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Timezone = tz
+	items := []stick.Value{
+		map[string]stick.Value{"name": "a", "active": true, "count": 1},
+		map[string]stick.Value{"name": "b", "active": false, "count": 2},
+		map[string]stick.Value{"name": "c", "active": true, "count": 3},
+	}
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		tpl      string
+		expected string
+	}{
+		{"format_datetime", `{{ date|format_datetime('short', 'short') }}`, "1/1/20, 7:00 AM"},
+		{"format_time timezone", `{{ '2020-01-01 12:00'|format_time(timezone='UTC') }}`, "5:00:00 PM"},
+		{"filter", `{{ items|filter(i => i.active)|length }}`, "2"},
+		{"filter key", `{{ ['a', 'b', 'c']|filter((v, k) => k > 0)|join }}`, "bc"},
+		{"filter map", `{{ {x: 1, y: 2, z: 3}|filter(v => v >= 2)|keys|join }}`, "yz"},
+		{"map", `{{ items|map(i => i.name)|join(', ') }}`, "a, b, c"},
+		{"map map", `{{ {x: 1, y: 2}|map((v, k) => k ~ v)|merge({z: 'z3'})|keys|join }}`, "xyz"},
+		{"filter and map", `{{ items|filter(i => i.active)|map(i => i.name|upper)|join }}`, "AC"},
+		{"reduce", `{{ items|reduce((c, i) => c + i.count, 0) }}`, "6"},
+		{"reduce without initial", `{{ [1, 2, 3]|reduce((c, i) => c ~ i) }}`, "123"},
+		{"reduce empty", `{{ []|reduce((c, i) => c + i, 10) }}`, "10"},
+		{"slice", `{% for i in [1, 2, 3, 4, 5]|slice(1, 2) %}{{ i }}{% endfor %} {{ '12345'|slice(-2) }}`, "23 45"},
+		{"slice named", `{{ [1, 2, 3]|slice(start=1)|join }}`, "23"},
+		{"sort", `{{ [3, 1, 2]|sort|join }} {{ items|sort((a, b) => b.count - a.count)|map(i => i.name)|join }}`, "123 cba"},
+		{"split", `{{ 'one,two,three'|split(',', 2)|join('|') }}`, "one|two,three"},
+		{"striptags", `{{ '<p>Hello <b>World</b></p>'|striptags('<b>') }}`, "Hello <b>World</b>"},
+		{"nl2br", `{{ text|nl2br }}`, "a<br />\n&lt;b&gt;"},
+		{"number_format", `{{ 9800.333|number_format(2) }} {{ 9800.333|number_format(decimal_point=',', decimal=1, thousand_sep='.') }}`, "9,800.33 9.800,3"},
+		{"date timezone", `{{ date|date('Y-m-d H:i T') }} {{ date|date('H:i T', 'Australia/Perth') }} {{ date|date('H:i T', false) }}`, "2020-01-01 07:00 EST 20:00 AWST 12:00 UTC"},
+		{"date string", `{{ '2020-06-01 12:00'|date('c') }} {{ 1577880000|date('Y-m-d H:i') }}`, "2020-06-01T12:00:00-04:00 2020-01-01 07:00"},
+		{"date string timezone", `{{ '2020-06-01 12:00'|date('H:i T', 'UTC') }}`, "16:00 UTC"},
+		{"date_modify", `{{ date|date_modify('+1 day')|date('m/d/Y') }}`, "01/02/2020"},
+		{"convert_encoding", `{{ 'abc'|convert_encoding('UTF-16BE', 'UTF-8')|length }}`, "6"},
+		{"column", `{{ items|column('name')|join }} {{ items|column('count', 'name')|keys|join }}`, "abc abc"},
+		{"find", `{{ items|find(i => i.count > 1).name }} {{ [1, 2]|find(v => v > 5)|default("none") }}`, "b none"},
+		{"spaceless", `{{ '<p> <b>a</b> </p>'|spaceless }}`, "<p><b>a</b></p>"},
+		{"spaceless tag", "{% spaceless %}\n<div>\n  <b>{{ 'a' }}</b>\n</div>\n{% endspaceless %}", "<div><b>a</b></div>"},
+		{"trim", `[{{ '  a.  '|trim(side='left') }}] [{{ '..a..'|trim('.', 'right') }}]`, "[a.  ] [..a]"},
+		{"format_number", `{{ 1234.5678|format_number({fraction_digit: 1}) }} {{ 0.25|format_number(style='percent') }}`, "1,234.6 25%"},
+		{"format_currency", `{{ 99.9|format_currency('GBP') }} {{ 5|format_currency(currency='USD', attrs={fraction_digit: 0}) }}`, "£99.90 $5"},
+		{"markdown_to_html", `{{ md|markdown_to_html }}`, "<h2>Hi</h2>\n<p>A <em>b</em></p>\n"},
+		{"named arguments", `{{ [1, 2]|join(glue='-') }} {{ [1, 2]|reduce(initial=10, arrow=(c, i) => c + i) }}`, "1-2 13"},
+	}
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		err := env.Execute(test.tpl, buf, map[string]stick.Value{"items": items, "date": date, "text": "a\n<b>", "md": "## Hi\n\nA *b*"})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%s:\n\texpected: %v\n\tgot: %v", test.name, test.expected, buf.String())
+		}
+	}
+}
+
+type sandboxUser struct {
+	Name     string
+	Password string
+}
+
+func TestSandboxedFilters(t *testing.T) {
+	env := stick.New(nil)
+	env.Filters = TwigFilters()
+	policy := &stick.SecurityPolicy{
+		Filters: []string{"column", "join"},
+		Fields:  map[string][]string{"filter.sandboxUser": {"Name"}},
+	}
+	if err := env.Register(stick.NewSandboxExtension(policy, true)); err != nil {
+		t.Fatal(err)
+	}
+	users := []stick.Value{sandboxUser{"a", "x"}, sandboxUser{"b", "y"}}
+	buf := &bytes.Buffer{}
+	err := env.Execute(`{{ users|column('Name')|join(',') }}|{{ users|column('Password')|join(',') }}`, buf, map[string]stick.Value{"users": users})
+	if eerr, ok := err.(*stick.ExecutionError); !ok {
+		t.Fatalf("expected ExecutionError, got %#v", err)
+	} else if serr, ok := eerr.Err.(*stick.SecurityError); !ok {
+		t.Fatalf("expected SecurityError, got %#v", eerr.Err)
+	} else if serr.Subject != "Password" || serr.Pos.Offset != 46 {
+		t.Errorf("unexpected SecurityError %s", serr)
+	}
+	if expected := "a,b|"; buf.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, buf.String())
+	}
+}
@@ -0,0 +1,244 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/shopspring/decimal"
+	"github.com/tystuyfzand/stick"
+)
+
+// A numberLocale describes how numbers are formatted in a locale.
+type numberLocale struct {
+	point          string // Decimal point.
+	sep            string // Thousands separator.
+	percentSuffix  string // Appended to percentages.
+	currencySuffix bool   // If true, the currency symbol follows the number.
+}
+
+// numberLocales contains the supported locales, by language. As in ICU,
+// non-breaking spaces are used between numbers and symbols.
+var numberLocales = map[string]numberLocale{
+	"en": {".", ",", "%", false},
+	"ja": {".", ",", "%", false},
+	"zh": {".", ",", "%", false},
+	"de": {",", ".", "\u00a0%", true},
+	"es": {",", ".", "\u00a0%", true},
+	"fr": {",", "\u202f", "\u00a0%", true},
+	"it": {",", ".", "%", true},
+	"pt": {",", ".", "%", true},
+	"ru": {",", "\u00a0", "\u00a0%", true},
+}
+
+// lookupLocale returns the numberLocale for the given locale, such as "de" or
+// "fr_CA". Unknown locales are formatted as English.
+func lookupLocale(locale stick.Value) numberLocale {
+	name := strings.ToLower(stick.CoerceString(locale))
+	if i := strings.IndexAny(name, "_-"); i >= 0 {
+		name = name[:i]
+	}
+	if l, ok := numberLocales[name]; ok {
+		return l
+	}
+	return numberLocales["en"]
+}
+
+// A currency describes a currency's symbol and number of decimal places.
+type currency struct {
+	symbol   string
+	decimals int
+}
+
+// currencies contains the symbols of common currencies, by ISO 4217 code.
+// Other currencies use their code as the symbol and 2 decimal places.
+var currencies = map[string]currency{
+	"AUD": {"A$", 2},
+	"BRL": {"R$", 2},
+	"CAD": {"CA$", 2},
+	"CHF": {"CHF", 2},
+	"CNY": {"CN¥", 2},
+	"EUR": {"€", 2},
+	"GBP": {"£", 2},
+	"INR": {"₹", 2},
+	"JPY": {"¥", 0},
+	"KRW": {"₩", 0},
+	"MXN": {"MX$", 2},
+	"NZD": {"NZ$", 2},
+	"USD": {"$", 2},
+}
+
+// numberAttrs contains the supported attributes of format_number and
+// format_currency.
+type numberAttrs struct {
+	minFraction int
+	maxFraction int
+	grouping    bool
+}
+
+// parseNumberAttrs applies the attributes in attrs to a, and returns false
+// if an attribute is not supported or a number of digits is negative.
+func parseNumberAttrs(attrs stick.Value, a numberAttrs) (numberAttrs, bool) {
+	ok := true
+	stick.Iterate(attrs, func(k, v stick.Value, l stick.Loop) (bool, error) {
+		name := stick.CoerceString(k)
+		f := stick.CoerceNumber(v)
+		if name != "grouping_used" && !(f >= 0 && f <= maxFractionDigits) {
+			ok = false
+			return true, nil
+		}
+		n := int(f)
+		switch name {
+		case "fraction_digit":
+			a.minFraction, a.maxFraction = n, n
+		case "min_fraction_digit":
+			a.minFraction = n
+			if a.maxFraction < n {
+				a.maxFraction = n
+			}
+		case "max_fraction_digit":
+			a.maxFraction = n
+			if a.minFraction > n {
+				a.minFraction = n
+			}
+		case "grouping_used":
+			a.grouping = stick.CoerceBool(v)
+		default:
+			ok = false
+			return true, nil
+		}
+		return false, nil
+	})
+	return a, ok
+}
+
+// maxFractionDigits is the largest number of fraction digits supported.
+const maxFractionDigits = 100
+
+// formatDecimal formats n according to a and the locale l. Numbers are
+// rounded half to even, as in ICU.
+func formatDecimal(n decimal.Decimal, a numberAttrs, l numberLocale) string {
+	s := n.RoundBank(int32(a.maxFraction)).StringFixed(int32(a.maxFraction))
+	if a.maxFraction > a.minFraction {
+		// Remove trailing zeros beyond the minimum number of fraction digits.
+		i := strings.IndexByte(s, '.')
+		min := i + a.minFraction
+		for len(s)-1 > min && s[len(s)-1] == '0' {
+			s = s[:len(s)-1]
+		}
+		if s[len(s)-1] == '.' {
+			s = s[:len(s)-1]
+		}
+	}
+	sep := l.sep
+	if !a.grouping {
+		sep = ""
+	}
+	return groupNumber(s, l.point, sep)
+}
+
+// filterFormatNumber takes three optional arguments: a hash of attributes,
+// the style, and the locale. The supported styles are "decimal", the
+// default, and "percent". The supported attributes are fraction_digit,
+// min_fraction_digit, max_fraction_digit and grouping_used. The locale
+// defaults to English.
+//
+// For compatibility with Twig, the third argument is type, which is ignored,
+// and the locale is the fourth argument.
+func filterFormatNumber(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	var attrs, locale stick.Value
+	style := "decimal"
+	if len(args) > 0 {
+		attrs = args[0]
+	}
+	if len(args) > 1 && args[1] != nil {
+		style = stick.CoerceString(args[1])
+	}
+	if len(args) > 3 {
+		locale = args[3]
+	}
+	l := lookupLocale(locale)
+	f := stick.CoerceNumber(val)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprint(f)
+	}
+	n := decimal.NewFromFloat(f)
+
+	var a numberAttrs
+	switch style {
+	case "decimal":
+		a = numberAttrs{0, 3, true}
+	case "percent":
+		a = numberAttrs{0, 0, true}
+		n = n.Mul(decimal.New(100, 0))
+	default:
+		// TODO: Report error
+		return nil
+	}
+	a, ok := parseNumberAttrs(attrs, a)
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	res := formatDecimal(n, a, l)
+	if style == "percent" {
+		res += l.percentSuffix
+	}
+	return res
+}
+
+// filterFormatCurrency takes the ISO 4217 code of a currency, and optionally
+// a hash of attributes and the locale, as for format_number. It returns val
+// formatted as an amount of the currency.
+func filterFormatCurrency(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	if len(args) < 1 {
+		// TODO: Report error
+		return nil
+	}
+	code := strings.ToUpper(stick.CoerceString(args[0]))
+	if code == "" {
+		// TODO: Report error
+		return nil
+	}
+	var attrs, locale stick.Value
+	if len(args) > 1 {
+		attrs = args[1]
+	}
+	if len(args) > 2 {
+		locale = args[2]
+	}
+	l := lookupLocale(locale)
+	c, ok := currencies[code]
+	if !ok {
+		c = currency{code, 2}
+	}
+	a, ok := parseNumberAttrs(attrs, numberAttrs{c.decimals, c.decimals, true})
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+
+	f := stick.CoerceNumber(val)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		// TODO: Report error
+		return nil
+	}
+	n := decimal.NewFromFloat(f)
+	res := formatDecimal(n.Abs(), a, l)
+	if l.currencySuffix {
+		res += "\u00a0" + c.symbol
+	} else {
+		symbol := c.symbol
+		if r := []rune(symbol); len(r) > 0 && unicode.IsLetter(r[len(r)-1]) {
+			// Symbols ending in a letter, such as "CHF", are separated
+			// from the number.
+			symbol += "\u00a0"
+		}
+		res = symbol + res
+	}
+	if n.Sign() < 0 && strings.Trim(formatDecimal(n.Abs(), a, numberLocales["en"]), "0.,") != "" {
+		res = "-" + res
+	}
+	return res
+}
@@ -0,0 +1,493 @@
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tystuyfzand/stick"
+	"github.com/tystuyfzand/stick/twig/escape"
+)
+
+// filterMarkdownToHTML converts val from Markdown to HTML. The common
+// indentation of all lines is removed first, so the Markdown may be indented
+// along with the template. The result is safe.
+//
+// Headings, paragraphs, block quotes, lists, code blocks, horizontal rules,
+// emphasis, code spans, links, images and line breaks are supported. Raw HTML
+// in val is escaped.
+func filterMarkdownToHTML(ctx stick.Context, val stick.Value, args ...stick.Value) stick.Value {
+	lines := dedent(strings.Split(strings.Replace(stick.CoerceString(val), "\r\n", "\n", -1), "\n"))
+	return stick.NewSafeValue(renderMarkdownBlocks(lines), "html")
+}
+
+// dedent removes the indentation common to all non-blank lines.
+func dedent(lines []string) []string {
+	min := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if min < 0 || n < min {
+			min = n
+		}
+	}
+	if min <= 0 {
+		return lines
+	}
+	res := make([]string, len(lines))
+	for i, l := range lines {
+		if len(l) >= min {
+			res[i] = l[min:]
+		} else {
+			res[i] = strings.TrimLeft(l, " \t")
+		}
+	}
+	return res
+}
+
+var (
+	mdHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdRule       = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdFence      = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*([^ \t`]*)")
+	mdListItem   = regexp.MustCompile(`^( {0,3})([-*+]|(\d{1,9})[.)])(?:[ \t]+|$)`)
+	mdSetext     = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	mdBlockquote = regexp.MustCompile(`^ {0,3}> ?`)
+)
+
+// renderMarkdownBlocks renders the block-level elements in lines.
+func renderMarkdownBlocks(lines []string) string {
+	res := ""
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case mdHeading.MatchString(line):
+			m := mdHeading.FindStringSubmatch(line)
+			tag := "h" + strconv.Itoa(len(m[1]))
+			res += "<" + tag + ">" + renderMarkdownInline(m[2]) + "</" + tag + ">\n"
+			i++
+
+		case mdRule.MatchString(line):
+			res += "<hr />\n"
+			i++
+
+		case mdFence.MatchString(line):
+			m := mdFence.FindStringSubmatch(line)
+			fence := m[1]
+			code := ""
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimLeft(lines[i], " "), fence) {
+					i++
+					break
+				}
+				code += lines[i] + "\n"
+			}
+			class := ""
+			if m[2] != "" {
+				class = ` class="language-` + escape.HTML(m[2]) + `"`
+			}
+			res += "<pre><code" + class + ">" + escape.HTML(code) + "</code></pre>\n"
+
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			code := ""
+			blank := ""
+			for ; i < len(lines); i++ {
+				l := lines[i]
+				if strings.TrimSpace(l) == "" {
+					blank += "\n"
+					continue
+				}
+				if !strings.HasPrefix(l, "    ") && !strings.HasPrefix(l, "\t") {
+					break
+				}
+				code += blank + strings.TrimPrefix(strings.TrimPrefix(l, "\t"), "    ") + "\n"
+				blank = ""
+			}
+			res += "<pre><code>" + escape.HTML(code) + "</code></pre>\n"
+
+		case mdBlockquote.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				quoted = append(quoted, mdBlockquote.ReplaceAllString(lines[i], ""))
+			}
+			res += "<blockquote>\n" + renderMarkdownBlocks(quoted) + "</blockquote>\n"
+
+		case mdListItem.MatchString(line):
+			var html string
+			html, i = renderMarkdownList(lines, i)
+			res += html
+
+		default:
+			para := []string{}
+			for ; i < len(lines); i++ {
+				l := lines[i]
+				if strings.TrimSpace(l) == "" || len(para) > 0 && (mdSetext.MatchString(l) || startsMarkdownBlock(l)) {
+					break
+				}
+				para = append(para, strings.TrimLeft(l, " \t"))
+			}
+			text := strings.TrimRight(strings.Join(para, "\n"), " \t")
+			if i < len(lines) && mdSetext.MatchString(lines[i]) && strings.TrimSpace(lines[i]) != "" {
+				tag := "h2"
+				if strings.TrimSpace(lines[i])[0] == '=' {
+					tag = "h1"
+				}
+				res += "<" + tag + ">" + renderMarkdownInline(text) + "</" + tag + ">\n"
+				i++
+				continue
+			}
+			res += "<p>" + renderMarkdownInline(text) + "</p>\n"
+		}
+	}
+	return res
+}
+
+// startsMarkdownBlock returns true if line interrupts a paragraph.
+func startsMarkdownBlock(line string) bool {
+	return mdHeading.MatchString(line) || mdRule.MatchString(line) ||
+		mdFence.MatchString(line) || mdBlockquote.MatchString(line) ||
+		mdListItem.MatchString(line)
+}
+
+// renderMarkdownList renders the list starting at lines[i], returning the
+// HTML and the index of the line after the list.
+func renderMarkdownList(lines []string, i int) (string, int) {
+	first := mdListItem.FindStringSubmatch(lines[i])
+	ordered := first[3] != ""
+	marker := first[2][len(first[2])-1:]
+	tag := "ul"
+	open := "<ul>"
+	if ordered {
+		tag = "ol"
+		open = "<ol>"
+		if n, _ := strconv.Atoi(first[3]); n != 1 {
+			open = `<ol start="` + strconv.Itoa(n) + `">`
+		}
+	}
+
+	// sameList returns the list item in line if it continues the list.
+	sameList := func(line string) []string {
+		m := mdListItem.FindStringSubmatch(line)
+		if m == nil || (m[3] != "") != ordered || m[2][len(m[2])-1:] != marker {
+			return nil
+		}
+		return m
+	}
+
+	var items [][]string
+	loose := false
+	for i < len(lines) {
+		m := sameList(lines[i])
+		if m == nil {
+			break
+		}
+		indent := len(m[0])
+		item := []string{lines[i][indent:]}
+		i++
+		for i < len(lines) {
+			l := lines[i]
+			if strings.TrimSpace(l) == "" {
+				// A blank line continues the item if the next line is indented.
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= indent {
+					item = append(item, "")
+					loose = true
+					i++
+					continue
+				}
+				break
+			}
+			if leadingSpaces(l) >= indent {
+				item = append(item, l[indent:])
+			} else if mdListItem.MatchString(l) || startsMarkdownBlock(l) {
+				break
+			} else {
+				// A lazy continuation of the item's paragraph.
+				item = append(item, strings.TrimLeft(l, " \t"))
+			}
+			i++
+		}
+		items = append(items, item)
+		if i+1 < len(lines) && strings.TrimSpace(lines[i]) == "" && sameList(lines[i+1]) != nil {
+			loose = true
+			i++
+		}
+	}
+
+	res := open + "\n"
+	for _, item := range items {
+		html := renderMarkdownBlocks(item)
+		if !loose {
+			// Tight lists do not wrap paragraphs in <p> tags.
+			html = strings.Replace(html, "<p>", "", -1)
+			html = strings.Replace(html, "</p>\n", "\n", -1)
+			html = strings.TrimSuffix(html, "\n")
+			if strings.Contains(html, "\n") {
+				html += "\n"
+			}
+		} else {
+			html = "\n" + html
+		}
+		res += "<li>" + html + "</li>\n"
+	}
+	return res + "</" + tag + ">\n", i
+}
+
+// leadingSpaces returns the number of spaces at the start of s, counting a
+// tab as four spaces.
+func leadingSpaces(s string) int {
+	n := 0
+	for _, c := range s {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+var mdAutolink = regexp.MustCompile(`^<((?:https?|ftp|mailto):[^<>\s]+)>`)
+
+// renderMarkdownInline renders the inline elements in s.
+func renderMarkdownInline(s string) string {
+	res := ""
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+1 < len(s) && s[i+1] == '\n' {
+				res += "<br />\n"
+				i++
+				continue
+			}
+			if i+1 < len(s) && unicode.IsPunct(rune(s[i+1])) || i+1 < len(s) && unicode.IsSymbol(rune(s[i+1])) {
+				res += escape.HTML(s[i+1 : i+2])
+				i++
+				continue
+			}
+
+		case '`':
+			n := 1
+			for i+n < len(s) && s[i+n] == '`' {
+				n++
+			}
+			ticks := s[i : i+n]
+			if end := strings.Index(s[i+n:], ticks); end >= 0 {
+				code := strings.Replace(s[i+n:i+n+end], "\n", " ", -1)
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				res += "<code>" + escape.HTML(code) + "</code>"
+				i += n + end + n - 1
+				continue
+			}
+			res += ticks
+			i += n - 1
+			continue
+
+		case '!', '[':
+			image := c == '!'
+			start := i
+			if image {
+				if i+1 >= len(s) || s[i+1] != '[' {
+					break
+				}
+				start++
+			}
+			text, url, title, end, ok := parseMarkdownLink(s, start)
+			if !ok {
+				break
+			}
+			i = end - 1
+			if !isSafeURL(url) {
+				// Links with unsafe URLs, such as javascript: URLs, are
+				// rendered as their text.
+				if image {
+					res += escape.HTML(text)
+				} else {
+					res += renderMarkdownInline(text)
+				}
+				continue
+			}
+			attrs := ""
+			if title != "" {
+				attrs = ` title="` + escape.HTML(title) + `"`
+			}
+			if image {
+				res += `<img src="` + escape.HTML(url) + `" alt="` + escape.HTML(text) + `"` + attrs + ` />`
+			} else {
+				res += `<a href="` + escape.HTML(url) + `"` + attrs + `>` + renderMarkdownInline(text) + `</a>`
+			}
+			continue
+
+		case '<':
+			if m := mdAutolink.FindStringSubmatch(s[i:]); m != nil {
+				res += `<a href="` + escape.HTML(m[1]) + `">` + escape.HTML(m[1]) + `</a>`
+				i += len(m[0]) - 1
+				continue
+			}
+
+		case '*', '_':
+			n := 1
+			if i+1 < len(s) && s[i+1] == c {
+				n = 2
+			}
+			if c == '_' && i > 0 && isWordByte(s[i-1]) {
+				// Underscores within words are not emphasis.
+				break
+			}
+			if i+n >= len(s) || s[i+n] == ' ' || s[i+n] == '\n' {
+				break
+			}
+			delim := s[i : i+n]
+			if end := findMarkdownDelim(s, i+n, delim); end >= 0 {
+				tag := "em"
+				if n == 2 {
+					tag = "strong"
+				}
+				res += "<" + tag + ">" + renderMarkdownInline(s[i+n:end]) + "</" + tag + ">"
+				i = end + n - 1
+				continue
+			}
+
+		case '\n':
+			if strings.HasSuffix(res, "  ") {
+				res = strings.TrimRight(res, " ") + "<br />\n"
+				continue
+			}
+			res = strings.TrimRight(res, " ")
+		}
+		if c < utf8.RuneSelf {
+			res += escape.HTML(s[i : i+1])
+		} else {
+			res += s[i : i+1]
+		}
+	}
+	return res
+}
+
+// findMarkdownDelim returns the index of the delimiter that closes an
+// emphasis started before s[start], or -1 if there is none.
+func findMarkdownDelim(s string, start int, delim string) int {
+	for j := start; j < len(s); j++ {
+		switch {
+		case s[j] == '\\':
+			j++
+		case s[j] == '`':
+			if end := strings.IndexByte(s[j+1:], '`'); end >= 0 {
+				j += end + 1
+			}
+		case strings.HasPrefix(s[j:], delim) && s[j-1] != ' ' && s[j-1] != '\n':
+			if len(delim) == 1 && j+1 < len(s) && s[j+1] == delim[0] {
+				// Skip a strong delimiter inside emphasis.
+				if end := findMarkdownDelim(s, j+2, delim+delim); end >= 0 {
+					j = end + 1
+					continue
+				}
+			}
+			if delim[0] == '_' && j+len(delim) < len(s) && isWordByte(s[j+len(delim)]) {
+				continue
+			}
+			return j
+		}
+	}
+	return -1
+}
+
+// isWordByte returns true if c is a letter or digit.
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// parseMarkdownLink parses a link, such as `[text](url "title")`, starting
+// at the "[" at s[i]. It returns the index after the link.
+func parseMarkdownLink(s string, i int) (text, url, title string, end int, ok bool) {
+	depth := 0
+	j := i
+	for ; j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == '[' {
+			depth++
+		} else if s[j] == ']' {
+			depth--
+			if depth == 0 {
+				break
+			}
+		}
+	}
+	if j >= len(s)-1 || s[j+1] != '(' {
+		return "", "", "", 0, false
+	}
+	close := markdownLinkEnd(s[j+2:])
+	if close < 0 {
+		return "", "", "", 0, false
+	}
+	text = s[i+1 : j]
+	dest := strings.TrimSpace(s[j+2 : j+2+close])
+	url = dest
+	if k := strings.IndexAny(dest, " \t\n"); k >= 0 {
+		url = dest[:k]
+		title = strings.TrimSpace(dest[k:])
+		if len(title) >= 2 && (title[0] == '"' || title[0] == '\'') && title[len(title)-1] == title[0] {
+			title = title[1 : len(title)-1]
+		} else {
+			return "", "", "", 0, false
+		}
+	}
+	url = strings.TrimSuffix(strings.TrimPrefix(url, "<"), ">")
+	return text, url, title, j + 3 + close, true
+}
+
+// markdownLinkEnd returns the index of the parenthesis closing the link
+// destination and title at the start of s, or -1 if it is not closed.
+// Parentheses within the destination must be balanced.
+func markdownLinkEnd(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '"', '\'':
+			// Skip titles, which may contain parentheses.
+			if i > 0 && (s[i-1] == ' ' || s[i-1] == '\t' || s[i-1] == '\n') {
+				if end := strings.IndexByte(s[i+1:], s[i]); end >= 0 {
+					i += end + 1
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// isSafeURL returns true if url is relative, or uses the http, https or
+// mailto scheme.
+func isSafeURL(url string) bool {
+	colon := strings.IndexByte(url, ':')
+	if colon < 0 || strings.IndexAny(url[:colon], "/?#") >= 0 {
+		return true
+	}
+	switch strings.ToLower(url[:colon]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
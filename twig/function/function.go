@@ -0,0 +1,421 @@
+// Package function provides built-in functions for Twig-compatibility.
+package function // import "github.com/tystuyfzand/stick/twig/function"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tystuyfzand/stick"
+	"github.com/tystuyfzand/stick/twig/filter"
+)
+
+// TwigFunctions returns a map containing all built-in Twig functions.
+func TwigFunctions() map[string]stick.Func {
+	return map[string]stick.Func{
+		"attribute":            funcAttribute,
+		"constant":             funcConstant,
+		"cycle":                funcCycle,
+		"date":                 funcDate,
+		"dump":                 funcDump,
+		"include":              funcInclude,
+		"max":                  funcMax,
+		"min":                  funcMin,
+		"random":               funcRandom,
+		"range":                funcRange,
+		"source":               funcSource,
+		"template_from_string": funcTemplateFromString,
+	}
+}
+
+// TwigFunctionParams returns a map containing the parameter names of the
+// built-in Twig functions, for use with named arguments.
+func TwigFunctionParams() map[string][]string {
+	return map[string][]string{
+		"attribute":            {"object", "method", "arguments"},
+		"constant":             {"constant"},
+		"cycle":                {"values", "position"},
+		"date":                 {"date", "timezone"},
+		"include":              {"template", "variables", "with_context", "ignore_missing"},
+		"random":               {"values", "max"},
+		"range":                {"low", "high", "step"},
+		"source":               {"name", "ignore_missing"},
+		"template_from_string": {"template"},
+	}
+}
+
+// funcAttribute takes an object, the name of an attribute, and an optional
+// array of arguments. It returns the attribute or the result of calling the
+// method with the given arguments.
+//
+// As with the "." operator, methods and fields are checked against the Env's
+// SecurityPolicy in sandboxed templates, and execution stops if they are not
+// allowed.
+func funcAttribute(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) < 2 {
+		return nil
+	}
+	var margs []stick.Value
+	if len(args) > 2 {
+		stick.Iterate(args[2], func(k, v stick.Value, l stick.Loop) (bool, error) {
+			margs = append(margs, v)
+			return false, nil
+		})
+	}
+	v, err := ctx.GetAttr(args[0], args[1], margs...)
+	if err != nil {
+		if _, ok := err.(*stick.SecurityError); ok {
+			ctx.Fail(err)
+		}
+		// TODO: Report other errors
+		return nil
+	}
+	return v
+}
+
+// funcConstant takes the name of a constant and returns its value from the
+// Env's Constants.
+func funcConstant(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) < 1 {
+		return nil
+	}
+	v, ok := ctx.Env().Constants[stick.CoerceString(args[0])]
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	return v
+}
+
+// funcCycle takes an array of values and a position, and returns the value
+// at the position, starting over from the beginning of the array as needed.
+func funcCycle(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) < 2 {
+		return nil
+	}
+	vals := values(args[0])
+	if len(vals) == 0 {
+		return nil
+	}
+	n := len(vals)
+	i := int(stick.CoerceNumber(args[1])) % n
+	if i < 0 {
+		i += n
+	}
+	return vals[i]
+}
+
+// funcDate converts the given value to a time.Time in the named timezone,
+// or the Env's Timezone if none is given. Date strings are parsed in the Env's
+// Timezone. With no arguments, it returns the current time.
+func funcDate(ctx stick.Context, args ...stick.Value) stick.Value {
+	var val stick.Value
+	if len(args) > 0 {
+		val = args[0]
+	}
+	loc := ctx.Env().Timezone
+	if len(args) > 1 && args[1] != nil {
+		l, err := time.LoadLocation(stick.CoerceString(args[1]))
+		if err != nil {
+			// TODO: Report error
+			return nil
+		}
+		loc = l
+	}
+	dt, ok := filter.ToTime(val, ctx.Env().Timezone)
+	if !ok {
+		// TODO: Report error
+		return nil
+	}
+	if loc != nil {
+		dt = dt.In(loc)
+	}
+	return dt
+}
+
+// funcDump returns a representation of each argument, or of all variables in
+// scope if no arguments are given.
+func funcDump(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) == 0 {
+		vars := ctx.Scope().All()
+		names := make([]string, 0, len(vars))
+		for k := range vars {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		res := ""
+		for _, k := range names {
+			res += fmt.Sprintf("%s: %#v\n", k, vars[k])
+		}
+		return res
+	}
+	res := ""
+	for _, v := range args {
+		res += fmt.Sprintf("%#v\n", v)
+	}
+	return res
+}
+
+// funcInclude takes a template, or an array of templates, and returns the
+// output of the first that exists. An optional hash of variables is merged
+// with the current variables, unless the third argument, with_context, is
+// false. If the fourth argument, ignore_missing, is true, an empty string is
+// returned if no template exists.
+func funcInclude(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) < 1 || args[0] == nil {
+		ctx.Fail(errors.New("include expects a template"))
+		return nil
+	}
+	withContext := len(args) < 3 || args[2] == nil || stick.CoerceBool(args[2])
+	ignoreMissing := len(args) > 3 && stick.CoerceBool(args[3])
+
+	vars := make(map[string]stick.Value)
+	if withContext {
+		vars = ctx.Scope().All()
+	}
+	if len(args) > 1 {
+		if with, ok := args[1].(map[string]stick.Value); ok {
+			for k, v := range with {
+				vars[k] = v
+			}
+		}
+	}
+
+	tpls := []stick.Value{args[0]}
+	if stick.IsArray(args[0]) {
+		tpls = values(args[0])
+	}
+	err := os.ErrNotExist
+	for _, tpl := range tpls {
+		var out string
+		out, err = ctx.Include(tpl, vars)
+		if err == nil {
+			return stick.NewSafeValue(out, "html", "html_attr", "js", "css", "url")
+		}
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	if ignoreMissing && os.IsNotExist(err) {
+		return ""
+	}
+	ctx.Fail(err)
+	return nil
+}
+
+// funcMax returns the largest of its arguments, or of the values in an array
+// if given a single argument.
+func funcMax(ctx stick.Context, args ...stick.Value) stick.Value {
+	vals := args
+	if len(args) == 1 && stick.IsIterable(args[0]) {
+		vals = values(args[0])
+	}
+	var res stick.Value
+	for i, v := range vals {
+		if i == 0 || less(res, v) {
+			res = v
+		}
+	}
+	return res
+}
+
+// funcMin returns the smallest of its arguments, or of the values in an array
+// if given a single argument.
+func funcMin(ctx stick.Context, args ...stick.Value) stick.Value {
+	vals := args
+	if len(args) == 1 && stick.IsIterable(args[0]) {
+		vals = values(args[0])
+	}
+	var res stick.Value
+	for i, v := range vals {
+		if i == 0 || less(v, res) {
+			res = v
+		}
+	}
+	return res
+}
+
+// less returns true if a is less than b. Strings that are not numeric are
+// compared as strings, and all other values as numbers.
+func less(a, b stick.Value) bool {
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		_, aerr := strconv.ParseFloat(as, 64)
+		_, berr := strconv.ParseFloat(bs, 64)
+		if aerr != nil || berr != nil {
+			return as < bs
+		}
+	}
+	return stick.CoerceNumber(a) < stick.CoerceNumber(b)
+}
+
+// funcRandom returns a random value. With no arguments, it returns a random
+// integer. Given an array, it returns a random element; given a string, a
+// random character; and given a number, a random integer between zero and the
+// number. If a second argument is given, it returns a random integer between
+// the two numbers.
+func funcRandom(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) == 0 || args[0] == nil {
+		if len(args) > 1 {
+			return randomInt(ctx, 0, stick.CoerceNumber(args[1]))
+		}
+		return float64(rand.Int31())
+	}
+	if len(args) > 1 {
+		return randomInt(ctx, stick.CoerceNumber(args[0]), stick.CoerceNumber(args[1]))
+	}
+	switch v := args[0].(type) {
+	case string:
+		runes := []rune(v)
+		if len(runes) == 0 {
+			return ""
+		}
+		return string(runes[rand.Intn(len(runes))])
+	}
+	if stick.IsIterable(args[0]) {
+		vals := values(args[0])
+		if len(vals) == 0 {
+			return nil
+		}
+		return vals[rand.Intn(len(vals))]
+	}
+	return randomInt(ctx, 0, stick.CoerceNumber(args[0]))
+}
+
+// randomInt returns a random integer between min and max, inclusive. The
+// bounds are clamped to the range of a 32-bit integer, and must not be NaN.
+func randomInt(ctx stick.Context, min, max float64) stick.Value {
+	if math.IsNaN(min) || math.IsNaN(max) {
+		ctx.Fail(errors.New("random expects numbers, got NaN"))
+		return nil
+	}
+	lo, hi := clampInt32(min), clampInt32(max)
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+	return float64(lo + rand.Int63n(hi-lo+1))
+}
+
+// clampInt32 returns n, truncated and clamped to the range of an int32.
+func clampInt32(n float64) int64 {
+	switch {
+	case n < math.MinInt32:
+		return math.MinInt32
+	case n > math.MaxInt32:
+		return math.MaxInt32
+	}
+	return int64(n)
+}
+
+// funcRange returns an array containing the numbers, or characters, from
+// low to high, inclusive. The optional third argument is the step between
+// each element.
+func funcRange(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) < 2 {
+		ctx.Fail(errors.New("range expects at least two parameters"))
+		return nil
+	}
+	step := 1.0
+	if len(args) > 2 && args[2] != nil {
+		step = math.Abs(stick.CoerceNumber(args[2]))
+		if step == 0 {
+			ctx.Fail(errors.New("range step must not be zero"))
+			return nil
+		}
+	}
+
+	ls, lok := args[0].(string)
+	hs, hok := args[1].(string)
+	chars := lok && hok && len(ls) == 1 && len(hs) == 1 && !isDigit(ls[0]) && !isDigit(hs[0])
+
+	var low, high float64
+	if chars {
+		low, high = float64(ls[0]), float64(hs[0])
+	} else {
+		low, high = stick.CoerceNumber(args[0]), stick.CoerceNumber(args[1])
+	}
+	n, err := ctx.Env().Limits.RangeSize(low, high, step)
+	if err != nil {
+		ctx.Fail(err)
+		return nil
+	}
+	if high < low {
+		step = -step
+	}
+
+	res := make([]stick.Value, n)
+	for i := range res {
+		v := low + float64(i)*step
+		if chars {
+			res[i] = string(rune(v))
+		} else {
+			res[i] = v
+		}
+	}
+	return res
+}
+
+// isDigit returns true if c is a decimal digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// funcSource returns the contents of the named template, without executing
+// it. If the second argument, ignore_missing, is true, an empty string is
+// returned if the template does not exist.
+func funcSource(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) < 1 {
+		return nil
+	}
+	ignoreMissing := len(args) > 1 && stick.CoerceBool(args[1])
+	tpl, err := ctx.Env().Loader.Load(stick.CoerceString(args[0]))
+	if err != nil {
+		if ignoreMissing {
+			return ""
+		}
+		// TODO: Report error
+		return nil
+	}
+	r := tpl.Contents()
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		// TODO: Report error
+		return nil
+	}
+	return string(b)
+}
+
+// funcTemplateFromString parses the given template source and returns a
+// *stick.CompiledTemplate, which can be passed to the include function or tag.
+func funcTemplateFromString(ctx stick.Context, args ...stick.Value) stick.Value {
+	if len(args) < 1 {
+		return nil
+	}
+	tpl, err := ctx.Env().LoadString(stick.CoerceString(args[0]))
+	if err != nil {
+		// TODO: Report error
+		return nil
+	}
+	return tpl
+}
+
+// values returns the values in the given array or map.
+func values(val stick.Value) []stick.Value {
+	var res []stick.Value
+	stick.Iterate(val, func(k, v stick.Value, l stick.Loop) (bool, error) {
+		res = append(res, v)
+		return false, nil
+	})
+	return res
+}
@@ -0,0 +1,240 @@
+package function
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tystuyfzand/stick"
+)
+
+type point struct {
+	X, Y float64
+}
+
+func (p point) Sum(n float64) float64 {
+	return p.X + p.Y + n
+}
+
+func TestFunctions(t *testing.T) {
+	env := stick.New(nil)
+	env.Constants["ANSWER"] = 42.0
+	ctx := stick.NewState("", nil, nil, env)
+	arr := []stick.Value{"a", "b", "c"}
+	tzEnv := stick.New(nil)
+	tzEnv.Timezone = time.UTC
+	tzCtx := stick.NewState("", nil, nil, tzEnv)
+	epoch := time.Unix(0, 0)
+
+	tests := []struct {
+		name     string
+		actual   stick.Value
+		expected stick.Value
+	}{
+		{"range", funcRange(ctx, 1, 3), []stick.Value{1.0, 2.0, 3.0}},
+		{"range step", funcRange(ctx, 0, 10, 5), []stick.Value{0.0, 5.0, 10.0}},
+		{"range descending", funcRange(ctx, 3, 1), []stick.Value{3.0, 2.0, 1.0}},
+		{"range letters", funcRange(ctx, "a", "c"), []stick.Value{"a", "b", "c"}},
+		{"cycle", funcCycle(ctx, arr, 4), "b"},
+		{"cycle negative", funcCycle(ctx, arr, -1), "c"},
+		{"cycle empty", funcCycle(ctx, []stick.Value{}, 1), nil},
+		{"constant", funcConstant(ctx, "ANSWER"), 42.0},
+		{"constant undefined", funcConstant(ctx, "MISSING"), nil},
+		{"max", funcMax(ctx, 1, 3.5, 2), 3.5},
+		{"max array", funcMax(ctx, []stick.Value{1, 5, 2}), 5},
+		{"max strings", funcMax(ctx, "apple", "pear", "banana"), "pear"},
+		{"min", funcMin(ctx, 4, 2, 8), 2},
+		{"min map", funcMin(ctx, map[string]stick.Value{"a": 3, "b": 1}), 1},
+		{"min none", funcMin(ctx), nil},
+		{"attribute", funcAttribute(ctx, point{1, 2}, "X"), 1.0},
+		{"attribute method", funcAttribute(ctx, point{1, 2}, "Sum", []stick.Value{3.0}), 6.0},
+		{"attribute map", funcAttribute(ctx, map[string]stick.Value{"a": "b"}, "a"), "b"},
+		{"date", funcDate(ctx, 0, "UTC"), time.Unix(0, 0).In(time.UTC)},
+		{"date string", funcDate(ctx, "1000", "UTC"), time.Unix(1000, 0).In(time.UTC)},
+		{"date invalid", funcDate(ctx, "not a date"), nil},
+		{"date invalid timezone", funcDate(ctx, 0, "Not/AZone"), nil},
+		{"date env timezone", funcDate(tzCtx, 0), time.Unix(0, 0).In(time.UTC)},
+		{"date env timezone string", funcDate(tzCtx, "2000-01-02"), time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"date long string", funcDate(tzCtx, "January 2, 2000"), time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"date pointer", funcDate(tzCtx, &epoch), time.Unix(0, 0).In(time.UTC)},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.actual, test.expected) {
+			t.Errorf("%s:\n\texpected: %#v\n\tgot: %#v", test.name, test.expected, test.actual)
+		}
+	}
+	// Date strings are parsed in the Env's Timezone, then converted.
+	dt, ok := funcDate(tzCtx, "2000-01-02", "America/New_York").(time.Time)
+	if !ok || !dt.Equal(time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)) || dt.Location().String() != "America/New_York" {
+		t.Errorf("date string timezone: unexpected result %v", dt)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	env := stick.New(nil)
+	ctx := stick.NewState("", nil, nil, env)
+	for i := 0; i < 20; i++ {
+		if v := stick.CoerceNumber(funcRandom(ctx, 5)); v < 0 || v > 5 {
+			t.Errorf("random(5): out of range: %v", v)
+		}
+		if v := stick.CoerceNumber(funcRandom(ctx, 10, 12)); v < 10 || v > 12 {
+			t.Errorf("random(10, 12): out of range: %v", v)
+		}
+		if v := stick.CoerceString(funcRandom(ctx, "abc")); !strings.Contains("abc", v) || len(v) != 1 {
+			t.Errorf("random('abc'): unexpected value: %v", v)
+		}
+		if v := funcRandom(ctx, []stick.Value{"x", "y"}); v != "x" && v != "y" {
+			t.Errorf("random(['x', 'y']): unexpected value: %v", v)
+		}
+		if v := stick.CoerceNumber(funcRandom(ctx, 1, 1e19)); v < 1 || v > math.MaxInt32 {
+			t.Errorf("random(1, 1e19): out of range: %v", v)
+		}
+		if v := stick.CoerceNumber(funcRandom(ctx, math.Inf(-1))); v < math.MinInt32 || v > 0 {
+			t.Errorf("random(-inf): out of range: %v", v)
+		}
+	}
+}
+
+func TestExecuteFunctions(t *testing.T) {
+	templates := map[string]string{
+		"hello.twig": "Hello, {{ name }}!",
+		"items.twig": "{% for i in items %}{{ i }}{% endfor %}",
+	}
+	env := stick.New(&stick.MemoryLoader{Templates: templates})
+	env.Functions = TwigFunctions()
+	env.FunctionParams = TwigFunctionParams()
+	vars := map[string]stick.Value{"name": "World"}
+
+	tests := []struct {
+		name     string
+		tpl      string
+		expected string
+	}{
+		{"range", `{% for i in range(1, 5, 2) %}{{ i }}{% endfor %}`, "135"},
+		{"range named", `{% for i in range(low=3, high=1) %}{{ i }}{% endfor %}`, "321"},
+		{"cycle", `{% for i in range(0, 3) %}{{ cycle(['odd', 'even'], i) }} {% endfor %}`, "odd even odd even "},
+		{"include", `{{ include('hello.twig') }}`, "Hello, World!"},
+		{"include variables", `{{ include('hello.twig', {name: 'Stick'}) }}`, "Hello, Stick!"},
+		{"include without context", `{{ include('items.twig', {items: [1, 2]}, false) }}`, "12"},
+		{"include first existing", `{{ include(['missing.twig', 'hello.twig']) }}`, "Hello, World!"},
+		{"include ignore missing", `{{ include('missing.twig', ignore_missing=true) }}`, ""},
+		{"source", `{{ source('hello.twig') }}`, "Hello, {{ name }}!"},
+		{"source ignore missing", `{{ source('missing.twig', true) }}`, ""},
+		{"template_from_string", `{{ include(template_from_string('Hi {{ name }}')) }}`, "Hi World"},
+		{"template_from_string include tag", `{% include template_from_string('{{ name }}') %}`, "World"},
+		{"dump", `{{ dump('a', 1) }}`, "\"a\"\n1\n"},
+		{"max min", `{{ max([1, 3, 2]) }}{{ min(4, 2) }}`, "32"},
+	}
+	for _, test := range tests {
+		templates[test.name] = test.tpl
+	}
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		err := env.Execute(test.name, buf, vars)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%s:\n\texpected: %v\n\tgot: %v", test.name, test.expected, buf.String())
+		}
+	}
+}
+
+func TestTemplateFromStringCache(t *testing.T) {
+	env := stick.New(nil)
+	env.Functions = TwigFunctions()
+	c := stick.NewMemoryTemplateCache(0)
+	env.Cache = c
+	for _, src := range []string{"a", "b"} {
+		tpl := `{{ include(template_from_string('` + src + `')) }}`
+		if err := env.Execute(tpl, &bytes.Buffer{}, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	// Only the two executed templates are cached, not the templates they
+	// created from strings.
+	if l := c.Len(); l != 2 {
+		t.Errorf("expected 2 cached templates, got %d", l)
+	}
+}
+
+func TestSandboxedAttribute(t *testing.T) {
+	env := stick.New(nil)
+	env.Functions = TwigFunctions()
+	policy := &stick.SecurityPolicy{
+		Functions: []string{"attribute"},
+		Fields:    map[string][]string{"function.point": {"X"}},
+	}
+	if err := env.Register(stick.NewSandboxExtension(policy, true)); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]stick.Value{"p": point{1, 2}}
+	buf := &bytes.Buffer{}
+	err := env.Execute(`{{ attribute(p, 'X') }}|{{ attribute(p, 'Y') }}`, buf, vars)
+	if eerr, ok := err.(*stick.ExecutionError); !ok {
+		t.Fatalf("expected ExecutionError, got %#v", err)
+	} else if serr, ok := eerr.Err.(*stick.SecurityError); !ok {
+		t.Fatalf("expected SecurityError, got %#v", eerr.Err)
+	} else if serr.Subject != "Y" || serr.Pos.Offset != 27 {
+		t.Errorf("unexpected SecurityError %s", serr)
+	}
+	if expected := "1|"; buf.String() != expected {
+		t.Errorf("expected %#v, got %#v", expected, buf.String())
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	templates := map[string]string{
+		"hello.twig":   "Hello, {{ name }}!",
+		"include.twig": "\n{{ include('hello.twig') }}{{ include('missing.twig') }}",
+		"tiny.twig":    `{% set x = range(0, 1, 1 / 10000000000000000000) %}`,
+	}
+	env := stick.New(&stick.MemoryLoader{Templates: templates})
+	env.Functions = TwigFunctions()
+	env.Limits.MaxRangeSize = 1000
+
+	tests := []struct {
+		name string
+		tpl  string
+		err  string
+	}{
+		{"range zero step", `{{ range(1, 3, 0) }}`, "range step must not be zero on line 1, column 3"},
+		{"range NaN", `{% set x = range(0, 0/0) %}`, "range bounds and step must be finite numbers on line 1, column 11"},
+		{"range limit", `{% for i in range(1, 100000) %}{% endfor %}`, "exceeded limit of 1000 range size on line 1, column 12 in range limit"},
+		{"include missing template", `{{ include() }}`, "include expects a template on line 1, column 3"},
+		{"random NaN", `{{ random(0/0) }}`, "random expects numbers, got NaN on line 1, column 3"},
+		{"random max NaN", `{{ random(0, 0/0) }}`, "random expects numbers, got NaN on line 1, column 3"},
+	}
+	for _, test := range tests {
+		templates[test.name] = test.tpl
+	}
+	for _, test := range tests {
+		err := env.Execute(test.name, &bytes.Buffer{}, nil)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %#v, got %v", test.name, test.err, err)
+		}
+	}
+	if eerr, ok := env.Execute("range limit", &bytes.Buffer{}, nil).(*stick.ExecutionError); !ok {
+		t.Errorf("range limit: expected ExecutionError, got %#v", eerr)
+	} else if _, ok := eerr.Err.(*stick.LimitError); !ok {
+		t.Errorf("range limit: expected LimitError, got %#v", eerr.Err)
+	}
+
+	env.Limits.MaxRangeSize = 0
+	err := env.Execute("tiny.twig", &bytes.Buffer{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exceeded limit of 2147483647 range size") {
+		t.Errorf("range tiny step: unexpected error %v", err)
+	}
+
+	err = env.Execute("include.twig", &bytes.Buffer{}, map[string]stick.Value{"name": "World"})
+	if eerr, ok := err.(*stick.ExecutionError); !ok || !os.IsNotExist(eerr.Err) {
+		t.Errorf("expected missing template error, got %#v", err)
+	} else if !strings.Contains(err.Error(), "on line 2, column 30 in include.twig") {
+		t.Errorf("unexpected error %s", err)
+	}
+}
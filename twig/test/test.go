@@ -0,0 +1,128 @@
+// Package test provides built-in tests for Twig-compatibility.
+package test // import "github.com/tystuyfzand/stick/twig/test"
+
+import (
+	"reflect"
+
+	"github.com/tystuyfzand/stick"
+)
+
+// TwigTests returns a map containing all built-in Twig tests.
+func TwigTests() map[string]stick.Test {
+	return map[string]stick.Test{
+		"constant":     testConstant,
+		"defined":      testDefined,
+		"divisible by": testDivisibleBy,
+		"empty":        testEmpty,
+		"even":         testEven,
+		"iterable":     testIterable,
+		"none":         testNull,
+		"null":         testNull,
+		"odd":          testOdd,
+		"same as":      testSameAs,
+	}
+}
+
+// testConstant takes one argument, the name of a constant in the Env's
+// Constants. It returns true if val is the same as the constant.
+func testConstant(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	if len(args) != 1 {
+		return false
+	}
+	c, ok := ctx.Env().Constants[stick.CoerceString(args[0])]
+	if !ok {
+		// TODO: Report error
+		return false
+	}
+	return same(val, c)
+}
+
+// testDefined returns true if val is not an undefined variable or attribute.
+func testDefined(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	_, ok := val.(stick.Undefined)
+	return !ok
+}
+
+// testDivisibleBy takes one argument, the divisor. Both val and the divisor
+// are coerced into integers.
+func testDivisibleBy(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	if len(args) != 1 {
+		return false
+	}
+	i := int(stick.CoerceNumber(args[0]))
+	if i == 0 {
+		return false
+	}
+	return int(stick.CoerceNumber(val))%i == 0
+}
+
+// testEmpty returns true if val is null, false, an empty string, or an
+// empty array or map.
+func testEmpty(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	switch v := val.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case stick.Stringer:
+		return v.String() == ""
+	}
+	if stick.IsIterable(val) {
+		l, _ := stick.Len(val)
+		return l == 0
+	}
+	return false
+}
+
+// testEven returns true if val, coerced into an integer, is even.
+func testEven(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	return int(stick.CoerceNumber(val))%2 == 0
+}
+
+// testIterable returns true if val is an array or map.
+func testIterable(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	return val != nil && stick.IsIterable(val)
+}
+
+// testNull returns true if val is null.
+func testNull(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	return val == nil
+}
+
+// testOdd returns true if val, coerced into an integer, is odd.
+func testOdd(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	return int(stick.CoerceNumber(val))%2 != 0
+}
+
+// testSameAs takes one argument and returns true if val is the same as it,
+// with no type coercion.
+func testSameAs(ctx stick.Context, val stick.Value, args ...stick.Value) bool {
+	if len(args) != 1 {
+		return false
+	}
+	return same(val, args[0])
+}
+
+// same returns true if a and b have the same type and value. Arrays and maps
+// are the same if they refer to the same underlying data.
+func same(a, b stick.Value) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if ra.Type() != rb.Type() {
+		return false
+	}
+	switch ra.Kind() {
+	case reflect.Slice:
+		return ra.Pointer() == rb.Pointer() && ra.Len() == rb.Len()
+	case reflect.Map, reflect.Func:
+		return ra.Pointer() == rb.Pointer()
+	}
+	if !ra.Type().Comparable() {
+		return false
+	}
+	return a == b
+}
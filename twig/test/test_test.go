@@ -0,0 +1,100 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tystuyfzand/stick"
+)
+
+type stringer string
+
+func (s stringer) String() string {
+	return string(s)
+}
+
+func TestTests(t *testing.T) {
+	env := stick.New(nil)
+	env.Constants["ANSWER"] = 42.0
+	ctx := stick.NewState("", nil, nil, env)
+	arr := []stick.Value{1, 2}
+	m := map[string]stick.Value{"a": 1}
+
+	tests := []struct {
+		name     string
+		actual   bool
+		expected bool
+	}{
+		{"defined", testDefined(ctx, nil), true},
+		{"defined undefined", testDefined(ctx, stick.Undefined{Name: "foo"}), false},
+		{"empty nil", testEmpty(ctx, nil), true},
+		{"empty false", testEmpty(ctx, false), true},
+		{"empty string", testEmpty(ctx, ""), true},
+		{"empty zero", testEmpty(ctx, 0), false},
+		{"empty string zero", testEmpty(ctx, "0"), false},
+		{"empty slice", testEmpty(ctx, []string{}), true},
+		{"empty map", testEmpty(ctx, map[string]stick.Value{}), true},
+		{"empty stringer", testEmpty(ctx, stringer("")), true},
+		{"not empty slice", testEmpty(ctx, arr), false},
+		{"null", testNull(ctx, nil), true},
+		{"null false", testNull(ctx, false), false},
+		{"even", testEven(ctx, 4), true},
+		{"even odd", testEven(ctx, 3.0), false},
+		{"odd", testOdd(ctx, -3), true},
+		{"odd even", testOdd(ctx, "10"), false},
+		{"iterable slice", testIterable(ctx, arr), true},
+		{"iterable map", testIterable(ctx, m), true},
+		{"iterable nil", testIterable(ctx, nil), false},
+		{"iterable string", testIterable(ctx, "abc"), false},
+		{"divisible by", testDivisibleBy(ctx, 9, 3), true},
+		{"divisible by not", testDivisibleBy(ctx, 10, 3), false},
+		{"divisible by zero", testDivisibleBy(ctx, 10, 0), false},
+		{"same as", testSameAs(ctx, 1.0, 1.0), true},
+		{"same as different type", testSameAs(ctx, 1, 1.0), false},
+		{"same as string number", testSameAs(ctx, "1", 1.0), false},
+		{"same as nil", testSameAs(ctx, nil, nil), true},
+		{"same as slice", testSameAs(ctx, arr, arr), true},
+		{"same as other slice", testSameAs(ctx, arr, []stick.Value{1, 2}), false},
+		{"same as map", testSameAs(ctx, m, m), true},
+		{"constant", testConstant(ctx, 42.0, "ANSWER"), true},
+		{"constant different", testConstant(ctx, "42", "ANSWER"), false},
+		{"constant undefined", testConstant(ctx, nil, "MISSING"), false},
+	}
+	for _, test := range tests {
+		if test.actual != test.expected {
+			t.Errorf("%s:\n\texpected: %v\n\tgot: %v", test.name, test.expected, test.actual)
+		}
+	}
+}
+
+func TestExecuteTests(t *testing.T) {
+	env := stick.New(nil)
+	env.Tests = TwigTests()
+	env.StrictVariables = true
+	vars := map[string]stick.Value{
+		"name":  "Tyler",
+		"null":  nil,
+		"items": map[string]stick.Value{"a": 1},
+	}
+	tests := []struct {
+		name     string
+		tpl      string
+		expected string
+	}{
+		{"defined", `{{ name is defined ? 'y' : 'n' }}{{ missing is defined ? 'y' : 'n' }}{{ null is defined ? 'y' : 'n' }}`, "yny"},
+		{"defined attribute", `{{ items.a is defined ? 'y' : 'n' }}{{ items.b is defined ? 'y' : 'n' }}{{ missing.b is not defined ? 'y' : 'n' }}`, "yny"},
+		{"two words", `{{ 9 is divisible by(3) ? 'y' : 'n' }}{{ name is same as('Tyler') ? 'y' : 'n' }}`, "yy"},
+		{"null", `{% if null is null and name is not none %}y{% endif %}`, "y"},
+	}
+	for _, test := range tests {
+		buf := &bytes.Buffer{}
+		err := env.Execute(test.tpl, buf, vars)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%s:\n\texpected: %v\n\tgot: %v", test.name, test.expected, buf.String())
+		}
+	}
+}
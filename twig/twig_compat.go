@@ -29,6 +29,8 @@ import (
 	"github.com/tystuyfzand/stick"
 	"github.com/tystuyfzand/stick/parse"
 	"github.com/tystuyfzand/stick/twig/filter"
+	"github.com/tystuyfzand/stick/twig/function"
+	"github.com/tystuyfzand/stick/twig/test"
 )
 
 // New creates a new, default Env that aims to be compatible with Twig.
@@ -39,10 +41,14 @@ func New(loader stick.Loader) *stick.Env {
 	}
 	env := &stick.Env{
 		Loader:    loader,
-		Functions: make(map[string]stick.Func),
+		Functions: function.TwigFunctions(),
 		Filters:   filter.TwigFilters(),
-		Tests:     make(map[string]stick.Test),
+		Tests:     test.TwigTests(),
 		Visitors:  make([]parse.NodeVisitor, 0),
+
+		FunctionParams: function.TwigFunctionParams(),
+		FilterParams:   filter.TwigFilterParams(),
+		Constants:      make(map[string]stick.Value),
 	}
 	env.Register(NewAutoEscapeExtension())
 	return env
@@ -0,0 +1,84 @@
+package stick
+
+import "github.com/tystuyfzand/stick/parse"
+
+// Undefined describes a reference to an undefined variable, attribute or macro.
+type Undefined struct {
+	Kind  string    // One of "variable", "attribute" or "macro".
+	Name  string    // The name of the variable, attribute or macro.
+	Value Value     // For attributes and macros, the value the name was looked up on.
+	Pos   parse.Pos // The position of the reference.
+	Err   error     // An error describing the reference.
+}
+
+// An UndefinedHandler is called when a template references an undefined
+// variable, attribute or macro.
+//
+// If the handler returns a non-nil Value, it is used in place of the undefined
+// value. If it returns an error, execution stops with that error. Otherwise,
+// the default behavior applies: undefined macros and, if the Env has
+// StrictVariables set, undefined variables and attributes cause an error, and
+// are treated as null otherwise.
+type UndefinedHandler func(ctx Context, u Undefined) (Value, error)
+
+// undefined handles a reference to an undefined variable, attribute or macro.
+func (s *State) undefined(u Undefined) (Value, error) {
+	if s.ignoreUndefined {
+		s.lastUndefined = &u
+		return nil, nil
+	}
+	if h := s.env.UndefinedHandler; h != nil {
+		v, err := h(s, u)
+		if err != nil || v != nil {
+			return v, err
+		}
+	}
+	if s.env.StrictVariables || u.Kind == "macro" {
+		return nil, u.Err
+	}
+	return nil, nil
+}
+
+// isolateUndefined stops undefined values being treated as null while the body
+// of a macro or arrow function called from the operand of the default filter,
+// the ?? operator or the defined test is executed. It returns a function that
+// restores the previous behavior.
+func (s *State) isolateUndefined() func() {
+	ignore, last := s.ignoreUndefined, s.lastUndefined
+	s.ignoreUndefined, s.lastUndefined = false, nil
+	return func() {
+		s.ignoreUndefined, s.lastUndefined = ignore, last
+	}
+}
+
+// evalDefault evaluates the given expression, treating undefined values as null
+// without calling the UndefinedHandler.
+func (s *State) evalDefault(exp parse.Expr) (Value, error) {
+	prev := s.ignoreUndefined
+	s.ignoreUndefined = true
+	defer func() {
+		s.ignoreUndefined = prev
+	}()
+	return s.EvalExpr(exp)
+}
+
+// evalDefined evaluates the operand of the "defined" test. If it is a variable
+// or attribute and is null because it is undefined, the Undefined is returned
+// instead. Other operands, such as function calls, are evaluated as usual.
+func (s *State) evalDefined(exp parse.Expr) (Value, error) {
+	switch exp.(type) {
+	case *parse.NameExpr, *parse.GetAttrExpr:
+	default:
+		return s.EvalExpr(exp)
+	}
+	prev := s.lastUndefined
+	s.lastUndefined = nil
+	defer func() {
+		s.lastUndefined = prev
+	}()
+	v, err := s.evalDefault(exp)
+	if err == nil && v == nil && s.lastUndefined != nil {
+		return *s.lastUndefined, nil
+	}
+	return v, err
+}
@@ -0,0 +1,125 @@
+package stick
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type undefinedUser struct {
+	Name string
+}
+
+func (u undefinedUser) Greet(name string) string {
+	return "Hello, " + name
+}
+
+func TestUndefined(t *testing.T) {
+	tpl := `{% import 'macros.twig' as m %}` +
+		`[{{ name }}][{{ user.Email }}][{{ items.missing }}][{{ missing|default('d') }}]`
+	macros := `{% import 'macros.twig' as m %}{{ m.missing() }}`
+	env := New(&MemoryLoader{map[string]string{
+		"index.twig":          tpl,
+		"macros.twig":         macros,
+		"method.twig":         `{{ user.Greet() }}`,
+		"default.twig":        `{% macro m() %}<{{ missing }}>{% endmacro %}{{ _self.m()|default('d') }}`,
+		"coalesce_macro.twig": `{% macro m() %}<{{ missing }}>{% endmacro %}{{ _self.m() ?? 'c' }}`,
+		"arrow.twig":          `{{ 1|call(x => missing) ?? 'a' }}`,
+		"coalesce.twig":       `{{ name ?? user.Email ?? 'none' }}`,
+		"defined.twig":        `{{ name is defined ? 'y' : 'n' }}{{ user.Email is not defined ? 'y' : 'n' }}{{ user.Name is defined ? 'y' : 'n' }}`,
+		"defined_call.twig":   `{{ f(missing) is defined ? 'y' : 'n' }}`,
+	}})
+	env.Functions["f"] = func(c Context, args ...Value) Value {
+		return args[0]
+	}
+	env.Tests["defined"] = func(c Context, val Value, args ...Value) bool {
+		_, ok := val.(Undefined)
+		return !ok
+	}
+	env.Filters["call"] = func(c Context, val Value, args ...Value) Value {
+		v, err := c.Call(args[0], val)
+		if err != nil {
+			c.Fail(err)
+		}
+		return v
+	}
+	env.Filters["default"] = func(c Context, val Value, args ...Value) Value {
+		if val == nil {
+			return args[0]
+		}
+		return val
+	}
+	ctx := map[string]Value{
+		"user":  undefinedUser{"Tyler"},
+		"items": map[string]Value{},
+	}
+	render := func(name string) (string, error) {
+		buf := &bytes.Buffer{}
+		err := env.Execute(name, buf, ctx)
+		return buf.String(), err
+	}
+
+	if res, err := render("index.twig"); err != nil || res != "[][][][d]" {
+		t.Errorf("lenient: unexpected result %#v, %v", res, err)
+	}
+	if _, err := render("macros.twig"); err == nil {
+		t.Errorf("lenient: expected error calling undefined macro")
+	}
+	if _, err := render("method.twig"); err == nil {
+		t.Errorf("lenient: expected error calling method with wrong arity")
+	}
+
+	if res, err := render("default.twig"); err != nil || res != "<>" {
+		t.Errorf("lenient: unexpected result %#v, %v", res, err)
+	}
+	if res, err := render("defined_call.twig"); err != nil || res != "y" {
+		t.Errorf("lenient: unexpected result %#v, %v", res, err)
+	}
+
+	env.StrictVariables = true
+	for _, tpl := range []string{"default.twig", "coalesce_macro.twig", "arrow.twig"} {
+		if _, err := render(tpl); err == nil || cause(err).Error() != `undefined variable "missing"` {
+			t.Errorf("strict: %s: unexpected error %v", tpl, err)
+		}
+	}
+	if _, err := render("index.twig"); err == nil || err.Error() != `execute: undefined variable "name" on line 1, column 35 in index.twig` {
+		t.Errorf("strict: unexpected error %v", err)
+	}
+	if res, err := render("coalesce.twig"); err != nil || res != "none" {
+		t.Errorf("strict: unexpected result %#v, %v", res, err)
+	}
+	if res, err := render("defined.twig"); err != nil || res != "nyy" {
+		t.Errorf("strict: unexpected result %#v, %v", res, err)
+	}
+	ctx["name"] = "n"
+	if _, err := render("index.twig"); err == nil {
+		t.Errorf("strict: expected error for undefined attribute")
+	}
+
+	var seen []string
+	env.UndefinedHandler = func(c Context, u Undefined) (Value, error) {
+		seen = append(seen, u.Kind+" "+u.Name)
+		switch u.Kind {
+		case "attribute":
+			return "?", nil
+		case "macro":
+			return nil, errors.New("no macro " + u.Name)
+		}
+		return nil, nil
+	}
+	if res, err := render("index.twig"); err != nil || res != "[n][?][?][d]" {
+		t.Errorf("handler: unexpected result %#v, %v", res, err)
+	}
+	if _, err := render("macros.twig"); err == nil || cause(err).Error() != "no macro missing" {
+		t.Errorf("handler: unexpected error %v", err)
+	}
+	delete(ctx, "name")
+	if _, err := render("index.twig"); err == nil {
+		t.Errorf("handler: expected strict error when handler returns nil")
+	}
+	expected := "[attribute Email attribute missing macro missing variable name]"
+	if got := fmt.Sprint(seen); got != expected {
+		t.Errorf("expected handler calls %s, got %s", expected, got)
+	}
+}
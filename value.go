@@ -214,9 +214,29 @@ func CoerceString(v Value) string {
 
 // GetAttr attempts to access the given value and return the specified attribute.
 func GetAttr(v Value, attr Value, args ...Value) (Value, error) {
+	return getAttr(v, attr, nil, args...)
+}
+
+// An undefinedAttrError describes a missing field, method, key or index, as
+// opposed to an error calling a method.
+type undefinedAttrError struct {
+	msg string
+}
+
+func (e *undefinedAttrError) Error() string {
+	return e.msg
+}
+
+// An attrCheck returns an error if the named method or field of the given type
+// may not be accessed.
+type attrCheck func(typ string, name string, method bool) error
+
+// getAttr works like GetAttr, additionally calling check, if it is not nil,
+// before accessing a struct field or method.
+func getAttr(v Value, attr Value, check attrCheck, args ...Value) (Value, error) {
 	r := reflect.Indirect(reflect.ValueOf(v))
 	if !r.IsValid() {
-		return nil, fmt.Errorf("getattr: value does not support attribute lookup: %v", v)
+		return nil, &undefinedAttrError{fmt.Sprintf("getattr: value does not support attribute lookup: %v", v)}
 	}
 	var retval reflect.Value
 	switch r.Kind() {
@@ -230,6 +250,11 @@ func GetAttr(v Value, attr Value, args ...Value) (Value, error) {
 				return nil, err
 			}
 		}
+		if check != nil {
+			if err := check(r.Type().String(), strval, retval.Kind() == reflect.Func); err != nil {
+				return nil, err
+			}
+		}
 	case reflect.Map:
 		retval = r.MapIndex(reflect.ValueOf(attr))
 	case reflect.Slice, reflect.Array:
@@ -239,7 +264,7 @@ func GetAttr(v Value, attr Value, args ...Value) (Value, error) {
 		}
 	}
 	if !retval.IsValid() {
-		return nil, fmt.Errorf("getattr: unable to locate attribute \"%s\" on \"%v\"", attr, v)
+		return nil, &undefinedAttrError{fmt.Sprintf("getattr: unable to locate attribute \"%s\" on \"%v\"", attr, v)}
 	}
 	if retval.Kind() == reflect.Func {
 		t := retval.Type()
@@ -283,7 +308,7 @@ func getMethod(v Value, name string) (reflect.Value, error) {
 	if retVal.IsValid() {
 		return retVal, nil
 	}
-	return retVal, fmt.Errorf("stick: unable to locate method \"%s\" on \"%v\"", name, v)
+	return retVal, &undefinedAttrError{fmt.Sprintf("stick: unable to locate method \"%s\" on \"%v\"", name, v)}
 }
 
 // An Iteratee is called for each step in a loop.